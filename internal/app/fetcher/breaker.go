@@ -0,0 +1,251 @@
+package fetcher
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/pkg/errors"
+)
+
+// BreakerState of circuit breaker.
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerSettings configure circuit breaker of every external host.
+type BreakerSettings struct {
+	// Failure ratio opening circuit, zero disables breaker
+	FailureRatio float64
+	// Minimum number of requests in interval to compute failure ratio
+	MinRequests int
+	// Interval of counting requests in closed state
+	Interval time.Duration
+	// Time of open state before probing external host
+	CoolDown time.Duration
+	// Successful probes in half-open state closing circuit
+	Probes int
+}
+
+// DefaultBreakerSettings opens circuit when half of requests fail.
+var DefaultBreakerSettings = BreakerSettings{
+	FailureRatio: 0.5,
+	MinRequests:  10,
+	Interval:     time.Minute,
+	CoolDown:     30 * time.Second,
+	Probes:       1,
+}
+
+// BreakerStats of single external host.
+type BreakerStats struct {
+	State     BreakerState `json:"state"`
+	Requests  int          `json:"requests"`
+	Failures  int          `json:"failures"`
+	ChangedAt time.Time    `json:"changedAt"`
+}
+
+// minSweep is number of circuits kept without sweeping idle ones.
+const minSweep = 64
+
+type circuit struct {
+	stats   BreakerStats
+	probing int
+}
+
+// BreakerFetcher decorates Fetcher with circuit breaker per external host.
+// Idle circuits are forgotten as new hosts appear, so circuits are kept for active hosts only.
+type BreakerFetcher struct {
+	fetcher  Fetcher
+	settings BreakerSettings
+
+	mx       sync.Mutex
+	circuits map[string]*circuit
+	// Number of circuits triggering sweep of idle ones
+	sweepAt int
+}
+
+// NewBreakerFetcher constructor.
+func NewBreakerFetcher(fetcher Fetcher, settings BreakerSettings) *BreakerFetcher {
+	if settings.Probes < 1 {
+		settings.Probes = 1
+	}
+	return &BreakerFetcher{
+		fetcher:  fetcher,
+		settings: settings,
+		circuits: make(map[string]*circuit),
+		sweepAt:  minSweep,
+	}
+}
+
+// Fetch data from external resource unless its circuit is open.
+func (f *BreakerFetcher) Fetch(ctx context.Context, id string, data *model.FetchData) (*model.Response, error) {
+	if data == nil {
+		return nil, ErrInvalidInputData
+	}
+	if f.settings.FailureRatio <= 0 {
+		return f.fetcher.Fetch(ctx, id, data)
+	}
+
+	host := data.HostName()
+	if err := f.allow(host); err != nil {
+		return &model.Response{
+			ID:    id,
+			Error: &model.FetchError{Kind: model.ErrorKindCircuitOpen, Message: err.Error()},
+		}, nil
+	}
+
+	resp, err := f.fetcher.Fetch(ctx, id, data)
+	if err != nil {
+		// Invalid input data says nothing about external host
+		f.cancel(host)
+		return nil, err
+	}
+	if local(resp) {
+		// Host did not answer, so probe is freed and circuit is kept
+		f.cancel(host)
+		return resp, nil
+	}
+	f.record(host, failed(resp))
+	return resp, nil
+}
+
+// allow checks circuit of host and reserves probe in half-open state.
+func (f *BreakerFetcher) allow(host string) error {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	c := f.circuit(host)
+	switch c.stats.State {
+	case BreakerOpen:
+		if time.Since(c.stats.ChangedAt) < f.settings.CoolDown {
+			return errors.Errorf("circuit of host %s is open", host)
+		}
+		f.setState(c, BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if c.probing >= f.settings.Probes {
+			return errors.Errorf("circuit of host %s is half-open", host)
+		}
+		c.probing++
+	}
+	return nil
+}
+
+// cancel frees probe reserved for request not sent to host.
+func (f *BreakerFetcher) cancel(host string) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	if c := f.circuit(host); c.stats.State == BreakerHalfOpen && c.probing > 0 {
+		c.probing--
+	}
+}
+
+// record result of request to host and switch circuit state.
+func (f *BreakerFetcher) record(host string, failure bool) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	c := f.circuit(host)
+	switch c.stats.State {
+	case BreakerClosed:
+		// Start new counting interval
+		if time.Since(c.stats.ChangedAt) >= f.settings.Interval {
+			f.setState(c, BreakerClosed)
+		}
+		c.stats.Requests++
+		if failure {
+			c.stats.Failures++
+		}
+		if c.stats.Requests >= f.settings.MinRequests &&
+			float64(c.stats.Failures)/float64(c.stats.Requests) >= f.settings.FailureRatio {
+			f.setState(c, BreakerOpen)
+		}
+	case BreakerHalfOpen:
+		if failure {
+			f.setState(c, BreakerOpen)
+			return
+		}
+		c.stats.Requests++
+		if c.stats.Requests >= f.settings.Probes {
+			f.setState(c, BreakerClosed)
+		}
+	}
+}
+
+// circuit returns circuit of host creating it on first use.
+// Must be called with mutex locked.
+func (f *BreakerFetcher) circuit(host string) *circuit {
+	c, ok := f.circuits[host]
+	if !ok {
+		f.sweep()
+		c = &circuit{stats: BreakerStats{State: BreakerClosed, ChangedAt: time.Now()}}
+		f.circuits[host] = c
+	}
+	return c
+}
+
+// sweep forgets idle circuits when number of circuits doubles since previous sweep.
+// Closed circuit is idle when its counting interval is over, since counters are reset anyway.
+// Open and half-open circuits are idle after cool down and counting interval without probes.
+// Must be called with mutex locked.
+func (f *BreakerFetcher) sweep() {
+	if len(f.circuits) < f.sweepAt {
+		return
+	}
+	for host, c := range f.circuits {
+		idle := time.Since(c.stats.ChangedAt)
+		if c.stats.State == BreakerClosed && idle >= f.settings.Interval ||
+			c.probing == 0 && idle >= f.settings.CoolDown+f.settings.Interval {
+			delete(f.circuits, host)
+		}
+	}
+	f.sweepAt = 2 * len(f.circuits)
+	if f.sweepAt < minSweep {
+		f.sweepAt = minSweep
+	}
+}
+
+// setState resets counters of circuit, must be called with mutex locked.
+func (f *BreakerFetcher) setState(c *circuit, state BreakerState) {
+	c.stats = BreakerStats{State: state, ChangedAt: time.Now()}
+	c.probing = 0
+}
+
+// Stats returns circuit states keyed by host name, idle circuits could be forgotten.
+func (f *BreakerFetcher) Stats() map[string]BreakerStats {
+	result := make(map[string]BreakerStats)
+	if f == nil {
+		return result
+	}
+	f.mx.Lock()
+	defer f.mx.Unlock()
+	for host, c := range f.circuits {
+		result[host] = c.stats
+	}
+	return result
+}
+
+// local reports response of request rejected or interrupted locally,
+// it says nothing about external host.
+func local(resp *model.Response) bool {
+	if resp.Error == nil {
+		return false
+	}
+	switch resp.Error.Kind {
+	case model.ErrorKindBlocked, model.ErrorKindCanceled, model.ErrorKindCircuitOpen:
+		return true
+	}
+	return false
+}
+
+// failed reports response answered by host or transport error as failure of external host.
+func failed(resp *model.Response) bool {
+	return resp.Error != nil || resp.Status >= http.StatusInternalServerError
+}
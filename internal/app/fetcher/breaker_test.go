@@ -0,0 +1,166 @@
+package fetcher_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBreakerFetcher_Fetch(t *testing.T) {
+	unavailable := &model.Response{Status: http.StatusServiceUnavailable}
+	refused := &model.Response{Error: &model.FetchError{Kind: model.ErrorKindConnect, Message: "connection refused"}}
+	ok := &model.Response{Status: http.StatusOK}
+
+	script := &scriptFetcher{responses: []*model.Response{
+		unavailable, refused, // open circuit of dead host
+		ok, // other host is not affected
+		ok, // successful probe closes circuit
+		unavailable, unavailable,
+		refused, // failed probe opens circuit again
+	}}
+	f := fetcher.NewBreakerFetcher(script, fetcher.BreakerSettings{
+		FailureRatio: 0.5,
+		MinRequests:  2,
+		Interval:     time.Minute,
+		CoolDown:     50 * time.Millisecond,
+		Probes:       1,
+	})
+	fetch := func(url string) *model.Response {
+		resp, err := f.Fetch(context.Background(), "id", &model.FetchData{Method: http.MethodGet, URL: url})
+		require.Nil(t, err)
+		return resp
+	}
+	requireOpen := func() {
+		calls := script.calls
+		resp := fetch("http://dead.com/path")
+		require.NotNil(t, resp.Error)
+		require.Equal(t, model.ErrorKindCircuitOpen, resp.Error.Kind)
+		require.Equal(t, calls, script.calls)
+		require.Equal(t, fetcher.BreakerOpen, f.Stats()["dead.com"].State)
+	}
+
+	fetch("http://dead.com")
+	fetch("http://Dead.com:80")
+	requireOpen()
+	require.Equal(t, http.StatusOK, fetch("http://alive.com").Status)
+	require.Equal(t, fetcher.BreakerClosed, f.Stats()["alive.com"].State)
+
+	time.Sleep(60 * time.Millisecond)
+	require.Equal(t, http.StatusOK, fetch("http://dead.com").Status)
+	require.Equal(t, fetcher.BreakerClosed, f.Stats()["dead.com"].State)
+
+	fetch("http://dead.com")
+	fetch("http://dead.com")
+	requireOpen()
+	time.Sleep(60 * time.Millisecond)
+	require.Equal(t, model.ErrorKindConnect, fetch("http://dead.com").Error.Kind)
+	requireOpen()
+	require.Equal(t, len(script.responses), script.calls)
+}
+
+func TestBreakerFetcher_LocalProbe(t *testing.T) {
+	unavailable := &model.Response{Status: http.StatusServiceUnavailable}
+	canceled := &model.Response{Error: &model.FetchError{Kind: model.ErrorKindCanceled, Message: "context canceled"}}
+	blocked := &model.Response{Error: &model.FetchError{Kind: model.ErrorKindBlocked, Message: "egress blocked"}}
+	script := &scriptFetcher{responses: []*model.Response{
+		unavailable,       // open circuit
+		canceled, blocked, // probes interrupted locally keep circuit half-open
+		unavailable, // failed probe opens circuit again
+	}}
+	f := fetcher.NewBreakerFetcher(script, fetcher.BreakerSettings{
+		FailureRatio: 0.5,
+		MinRequests:  1,
+		Interval:     time.Minute,
+		CoolDown:     10 * time.Millisecond,
+		Probes:       1,
+	})
+	fetch := func() *model.Response {
+		resp, err := f.Fetch(context.Background(), "id", &model.FetchData{Method: http.MethodGet, URL: "http://dead.com"})
+		require.Nil(t, err)
+		return resp
+	}
+
+	fetch()
+	require.Equal(t, fetcher.BreakerOpen, f.Stats()["dead.com"].State)
+	time.Sleep(15 * time.Millisecond)
+
+	// Probe slot is freed, so next probe is allowed at once
+	require.Equal(t, model.ErrorKindCanceled, fetch().Error.Kind)
+	require.Equal(t, fetcher.BreakerHalfOpen, f.Stats()["dead.com"].State)
+	require.Equal(t, model.ErrorKindBlocked, fetch().Error.Kind)
+	require.Equal(t, fetcher.BreakerHalfOpen, f.Stats()["dead.com"].State)
+	require.Equal(t, 0, f.Stats()["dead.com"].Requests)
+
+	require.Equal(t, http.StatusServiceUnavailable, fetch().Status)
+	require.Equal(t, fetcher.BreakerOpen, f.Stats()["dead.com"].State)
+	require.Equal(t, len(script.responses), script.calls)
+}
+
+func TestBreakerFetcher_Idle(t *testing.T) {
+	unavailable := &model.Response{Status: http.StatusServiceUnavailable}
+	script := &scriptFetcher{responses: []*model.Response{unavailable, unavailable}}
+	f := fetcher.NewBreakerFetcher(script, fetcher.BreakerSettings{
+		FailureRatio: 0.5,
+		MinRequests:  2,
+		Interval:     10 * time.Millisecond,
+		CoolDown:     time.Minute,
+		Probes:       1,
+	})
+	fetch := func(url string) {
+		_, err := f.Fetch(context.Background(), "id", &model.FetchData{Method: http.MethodGet, URL: url})
+		require.Nil(t, err)
+	}
+	fetch("http://dead.com")
+	fetch("http://dead.com")
+	require.Equal(t, fetcher.BreakerOpen, f.Stats()["dead.com"].State)
+
+	// Closed circuits are forgotten after counting interval, open ones are kept until cool down
+	ok := &model.Response{Status: http.StatusOK}
+	for i := 0; i < 1000; i++ {
+		script.responses = append(script.responses, ok)
+		fetch(fmt.Sprintf("http://host-%d.com", i))
+		if i%100 == 0 {
+			time.Sleep(15 * time.Millisecond)
+		}
+	}
+	stats := f.Stats()
+	require.True(t, len(stats) <= 256, "%d circuits are kept", len(stats))
+	require.Equal(t, fetcher.BreakerOpen, stats["dead.com"].State)
+}
+
+func TestBreakerFetcher_Disabled(t *testing.T) {
+	unavailable := &model.Response{Status: http.StatusServiceUnavailable}
+	script := &scriptFetcher{responses: []*model.Response{unavailable, unavailable, unavailable}}
+	f := fetcher.NewBreakerFetcher(script, fetcher.BreakerSettings{MinRequests: 1})
+
+	for range script.responses {
+		resp, err := f.Fetch(context.Background(), "id", &model.FetchData{Method: http.MethodGet, URL: "http://dead.com"})
+		require.Nil(t, err)
+		require.Equal(t, http.StatusServiceUnavailable, resp.Status)
+	}
+	require.Empty(t, f.Stats())
+}
+
+func TestBreakerFetcher_InvalidData(t *testing.T) {
+	f := fetcher.NewBreakerFetcher(fetcher.NewMockFetcher(), fetcher.BreakerSettings{
+		FailureRatio: 0.5,
+		MinRequests:  1,
+		CoolDown:     time.Minute,
+	})
+
+	// Invalid input data is not failure of external host
+	for i := 0; i < 3; i++ {
+		_, err := f.Fetch(context.Background(), "id", &model.FetchData{Method: "FETCH", URL: "http://google.com"})
+		require.Equal(t, fetcher.ErrWrongHTTPMethod, err)
+	}
+	require.Equal(t, fetcher.BreakerClosed, f.Stats()["google.com"].State)
+
+	_, err := f.Fetch(context.Background(), "id", nil)
+	require.Equal(t, fetcher.ErrInvalidInputData, err)
+}
@@ -0,0 +1,193 @@
+package fetcher
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultDeniedNetworks are loopback, private, link-local (cloud metadata)
+// and other special purpose networks unavailable for fetching.
+var DefaultDeniedNetworks = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+}
+
+// nat64Prefix is well-known prefix of NAT64 addresses embedding IPv4 address in last 32 bits.
+var nat64Prefix = &net.IPNet{IP: net.ParseIP("64:ff9b::"), Mask: net.CIDRMask(96, 8*net.IPv6len)}
+
+// DefaultSchemes are URL schemes allowed by default.
+var DefaultSchemes = []string{"http", "https"}
+
+// EgressPolicy restricts external resources available for fetching.
+// Addresses are checked at dial time after DNS resolution,
+// so DNS rebinding could not bypass the policy.
+type EgressPolicy struct {
+	// Allowed URL schemes, empty list allows DefaultSchemes
+	Schemes []string
+	// Allowed destination ports, empty list allows any port
+	Ports []int
+	// Networks allowed even if they are denied
+	Allow []*net.IPNet
+	// Denied networks
+	Deny []*net.IPNet
+}
+
+// NewEgressPolicy creates policy from CIDR lists.
+// Single IP addresses are accepted as host networks.
+func NewEgressPolicy(schemes []string, ports []int, allow, deny []string) (*EgressPolicy, error) {
+	allowNets, err := ParseNetworks(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := ParseNetworks(deny)
+	if err != nil {
+		return nil, err
+	}
+	return &EgressPolicy{
+		Schemes: schemes,
+		Ports:   ports,
+		Allow:   allowNets,
+		Deny:    denyNets,
+	}, nil
+}
+
+// ParseNetworks parses CIDR list, single IP addresses are accepted as host networks.
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	result := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if cidr = strings.TrimSpace(cidr); len(cidr) == 0 {
+			continue
+		}
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return nil, errors.Errorf("invalid IP address %s", cidr)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			result = append(result, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, network)
+	}
+	return result, nil
+}
+
+// checkScheme returns ErrEgressBlocked for disallowed URL scheme.
+func (p *EgressPolicy) checkScheme(u *url.URL) error {
+	schemes := p.Schemes
+	if len(schemes) == 0 {
+		schemes = DefaultSchemes
+	}
+	for _, scheme := range schemes {
+		if strings.EqualFold(u.Scheme, scheme) {
+			return nil
+		}
+	}
+	return errors.Wrapf(ErrEgressBlocked, "scheme %s is not allowed", u.Scheme)
+}
+
+// checkAddress returns ErrEgressBlocked for disallowed resolved address.
+func (p *EgressPolicy) checkAddress(address string) error {
+	host, portValue, err := net.SplitHostPort(address)
+	if err != nil {
+		return errors.Wrapf(ErrEgressBlocked, "invalid address %s", address)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return errors.Wrapf(ErrEgressBlocked, "unresolved address %s", address)
+	}
+
+	// Check destination port
+	if len(p.Ports) > 0 {
+		port, err := strconv.Atoi(portValue)
+		if err != nil || !containsPort(p.Ports, port) {
+			return errors.Wrapf(ErrEgressBlocked, "port %s is not allowed", portValue)
+		}
+	}
+
+	// Check destination network
+	if p.denied(ip) {
+		return errors.Wrapf(ErrEgressBlocked, "address %s is denied", ip)
+	}
+	return nil
+}
+
+// denied reports whether address is in denied network and not in allowed one.
+// IPv4 address embedded in NAT64 or 6to4 address is checked as well,
+// so translated address could not reach denied IPv4 network.
+func (p *EgressPolicy) denied(ip net.IP) bool {
+	if containsIP(p.Allow, ip) {
+		return false
+	}
+	if containsIP(p.Deny, ip) {
+		return true
+	}
+	if ip4 := embeddedIPv4(ip); ip4 != nil {
+		return p.denied(ip4)
+	}
+	return false
+}
+
+// embeddedIPv4 returns IPv4 address embedded in NAT64 (64:ff9b::/96)
+// or 6to4 (2002::/16) address, it is nil for other addresses.
+func embeddedIPv4(ip net.IP) net.IP {
+	if ip.To4() != nil || len(ip) != net.IPv6len {
+		return nil
+	}
+	switch {
+	case nat64Prefix.Contains(ip):
+		return net.IPv4(ip[12], ip[13], ip[14], ip[15]).To4()
+	case ip[0] == 0x20 && ip[1] == 0x02:
+		return net.IPv4(ip[2], ip[3], ip[4], ip[5]).To4()
+	}
+	return nil
+}
+
+// control checks connection address before dialing.
+func (p *EgressPolicy) control(network, address string, _ syscall.RawConn) error {
+	return p.checkAddress(address)
+}
+
+func containsPort(ports []int, port int) bool {
+	for _, p := range ports {
+		if p == port {
+			return true
+		}
+	}
+	return false
+}
+
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
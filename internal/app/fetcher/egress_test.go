@@ -0,0 +1,125 @@
+package fetcher_test
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPFetcher_Egress(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/final", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/ftp", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "ftp://example.com/file", http.StatusFound)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	u, err := url.Parse(ts.URL)
+	require.Nil(t, err)
+	_, portValue, err := net.SplitHostPort(u.Host)
+	require.Nil(t, err)
+	port, err := strconv.Atoi(portValue)
+	require.Nil(t, err)
+
+	testCases := []struct {
+		name   string
+		url    string
+		ports  []int
+		allow  []string
+		status int
+		kind   model.ErrorKind
+	}{
+		{
+			name: "Loopback is denied",
+			url:  ts.URL + "/final",
+			kind: model.ErrorKindBlocked,
+		},
+		{
+			name: "Cloud metadata is denied",
+			url:  "http://169.254.169.254/latest/meta-data/",
+			kind: model.ErrorKindBlocked,
+		},
+		{
+			name: "NAT64 address of loopback is denied",
+			url:  "http://[64:ff9b::7f00:1]/",
+			kind: model.ErrorKindBlocked,
+		},
+		{
+			name: "6to4 address of private network is denied",
+			url:  "http://[2002:c0a8:101::1]/",
+			kind: model.ErrorKindBlocked,
+		},
+		{
+			name: "Scheme is not allowed",
+			url:  "ftp://example.com/file",
+			kind: model.ErrorKindBlocked,
+		},
+		{
+			name:   "Allowed network",
+			url:    ts.URL + "/final",
+			allow:  []string{"127.0.0.0/8"},
+			status: http.StatusOK,
+		},
+		{
+			name:  "Port is not allowed",
+			url:   ts.URL + "/final",
+			ports: []int{80, 443},
+			allow: []string{"127.0.0.1"},
+			kind:  model.ErrorKindBlocked,
+		},
+		{
+			name:  "Redirect scheme is not allowed",
+			url:   ts.URL + "/ftp",
+			ports: []int{port},
+			allow: []string{"127.0.0.1"},
+			kind:  model.ErrorKindBlocked,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := fetcher.NewEgressPolicy(nil, tc.ports, tc.allow, fetcher.DefaultDeniedNetworks)
+			require.Nil(t, err)
+			f := fetcher.NewHTTPFetcher(500*time.Millisecond, 0, nil, policy, false)
+
+			resp, err := f.Fetch(context.Background(), "id", &model.FetchData{
+				Method: http.MethodGet,
+				URL:    tc.url,
+			})
+			require.Nil(t, err)
+			require.Equal(t, tc.status, resp.Status)
+			if len(tc.kind) == 0 {
+				require.Nil(t, resp.Error)
+				return
+			}
+			require.NotNil(t, resp.Error)
+			require.Equal(t, tc.kind, resp.Error.Kind)
+			require.NotEmpty(t, resp.Error.Message)
+		})
+	}
+}
+
+func TestParseNetworks(t *testing.T) {
+	networks, err := fetcher.ParseNetworks([]string{"10.0.0.0/8", " 192.168.1.1 ", "", "::1"})
+	require.Nil(t, err)
+	require.Equal(t, 3, len(networks))
+	require.Equal(t, "10.0.0.0/8", networks[0].String())
+	require.Equal(t, "192.168.1.1/32", networks[1].String())
+	require.Equal(t, "::1/128", networks[2].String())
+
+	_, err = fetcher.ParseNetworks([]string{"localhost"})
+	require.NotNil(t, err)
+
+	_, err = fetcher.ParseNetworks([]string{"10.0.0.0/33"})
+	require.NotNil(t, err)
+}
@@ -1,9 +1,60 @@
 package fetcher
 
-import "github.com/pkg/errors"
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/pkg/errors"
+)
 
 var (
 	ErrInvalidInputData    = errors.New("invalid input data")
 	ErrCreatingHTTPRequest = errors.New("error creating HTTP request")
 	ErrWrongHTTPMethod     = errors.New("wrong HTTP method")
+	ErrInvalidBody         = errors.New("invalid request body")
+	ErrEgressBlocked       = errors.New("blocked by egress policy")
+	ErrInsecureNotAllowed  = errors.New("skipping TLS verification is not allowed")
 )
+
+// newFetchError classifies transport error from HTTP client.
+func newFetchError(err error) *model.FetchError {
+	return &model.FetchError{
+		Kind:    errorKind(err),
+		Message: err.Error(),
+	}
+}
+
+func errorKind(err error) model.ErrorKind {
+	var (
+		dnsErr     *net.DNSError
+		netErr     net.Error
+		opErr      *net.OpError
+		recordErr  tls.RecordHeaderError
+		authErr    x509.UnknownAuthorityError
+		hostErr    x509.HostnameError
+		invalidErr x509.CertificateInvalidError
+	)
+
+	switch {
+	case errors.Is(err, ErrEgressBlocked):
+		return model.ErrorKindBlocked
+	case errors.Is(err, context.Canceled):
+		return model.ErrorKindCanceled
+	case errors.As(err, &dnsErr):
+		return model.ErrorKindDNS
+	case errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return model.ErrorKindTimeout
+	case errors.As(err, &recordErr),
+		errors.As(err, &authErr),
+		errors.As(err, &hostErr),
+		errors.As(err, &invalidErr):
+		return model.ErrorKindTLS
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return model.ErrorKindConnect
+	}
+	return model.ErrorKindOther
+}
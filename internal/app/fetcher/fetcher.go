@@ -1,9 +1,13 @@
 package fetcher
 
-import "github.com/ahamtat/itvbackend/internal/app/model"
+import (
+	"context"
+
+	"github.com/ahamtat/itvbackend/internal/app/model"
+)
 
 // Fetcher interface for external resource.
 type Fetcher interface {
-	// Fetch data from external resource.
-	Fetch(ID string, data *model.FetchData) (*model.Response, error)
+	// Fetch data from external resource, done context interrupts fetching.
+	Fetch(ctx context.Context, ID string, data *model.FetchData) (*model.Response, error)
 }
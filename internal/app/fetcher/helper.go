@@ -1,19 +1,52 @@
 package fetcher
 
 import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
 	"net/http"
+	"net/textproto"
 	"net/url"
+	"strings"
 
 	"github.com/ahamtat/itvbackend/internal/app/model"
 )
 
-func checkFetchData(data *model.FetchData) error {
+// DefaultMethods are standard HTTP methods allowed by default. CONNECT opening
+// tunnels and TRACE reflecting request headers are allowed only if listed explicitly.
+var DefaultMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodPost,
+	http.MethodPut,
+	http.MethodPatch,
+	http.MethodDelete,
+	http.MethodOptions,
+}
+
+// newMethodSet creates allow-list of HTTP methods.
+// Empty methods list allows DefaultMethods.
+func newMethodSet(methods []string) map[string]bool {
+	if len(methods) == 0 {
+		methods = DefaultMethods
+	}
+	result := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		result[strings.ToUpper(strings.TrimSpace(m))] = true
+	}
+	return result
+}
+
+func checkFetchData(data *model.FetchData, methods map[string]bool) error {
 	if data == nil {
 		return ErrInvalidInputData
 	}
 
 	// Check method type
-	if m := data.Method; m != http.MethodGet && m != http.MethodPost && m != http.MethodDelete {
+	if !methods[data.Method] {
 		return ErrWrongHTTPMethod
 	}
 
@@ -22,5 +55,169 @@ func checkFetchData(data *model.FetchData) error {
 		return ErrInvalidInputData
 	}
 
-	return nil
+	return checkBody(data)
+}
+
+func checkBody(data *model.FetchData) error {
+	switch data.BodyEncoding {
+	case "", model.BodyEncodingText, model.BodyEncodingForm:
+		return nil
+	case model.BodyEncodingBase64:
+		if _, err := base64.StdEncoding.DecodeString(data.Body); err != nil {
+			return ErrInvalidBody
+		}
+		return nil
+	case model.BodyEncodingMultipart:
+		for i := range data.Parts {
+			if len(data.Parts[i].Name) == 0 {
+				return ErrInvalidBody
+			}
+			if _, err := partContent(&data.Parts[i]); err != nil {
+				return ErrInvalidBody
+			}
+		}
+		return nil
+	}
+	return ErrInvalidBody
+}
+
+// newRequestBody encodes request body for external resource.
+// Content type is returned for encodings defining it.
+func newRequestBody(data *model.FetchData) (io.Reader, string, error) {
+	switch data.BodyEncoding {
+	case model.BodyEncodingBase64:
+		buff, err := base64.StdEncoding.DecodeString(data.Body)
+		if err != nil {
+			return nil, "", ErrInvalidBody
+		}
+		return bytes.NewReader(buff), "", nil
+	case model.BodyEncodingForm:
+		return strings.NewReader(url.Values(data.Form).Encode()), "application/x-www-form-urlencoded", nil
+	case model.BodyEncodingMultipart:
+		return newMultipartBody(data.Parts)
+	}
+
+	// Plain text body
+	if len(data.Body) == 0 {
+		return nil, "", nil
+	}
+	return strings.NewReader(data.Body), "", nil
+}
+
+var quoteEscaper = strings.NewReplacer("\\", "\\\\", `"`, "\\\"")
+
+func newMultipartBody(parts []model.Part) (io.Reader, string, error) {
+	buff := &bytes.Buffer{}
+	writer := multipart.NewWriter(buff)
+	for i := range parts {
+		part := &parts[i]
+		content, err := partContent(part)
+		if err != nil {
+			return nil, "", ErrInvalidBody
+		}
+
+		// Make part headers
+		header := textproto.MIMEHeader{}
+		disposition := fmt.Sprintf(`form-data; name="%s"`, quoteEscaper.Replace(part.Name))
+		if len(part.FileName) > 0 {
+			disposition += fmt.Sprintf(`; filename="%s"`, quoteEscaper.Replace(part.FileName))
+		}
+		header.Set("Content-Disposition", disposition)
+		if len(part.ContentType) > 0 {
+			header.Set("Content-Type", part.ContentType)
+		} else if len(part.FileName) > 0 {
+			header.Set("Content-Type", "application/octet-stream")
+		}
+
+		w, err := writer.CreatePart(header)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := w.Write(content); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return buff, writer.FormDataContentType(), nil
+}
+
+func partContent(part *model.Part) ([]byte, error) {
+	if part.Base64 {
+		return base64.StdEncoding.DecodeString(part.Body)
+	}
+	return []byte(part.Body), nil
+}
+
+// hopHeaders are meaningful only for a single transport-level connection
+// and must not be forwarded to external resource (RFC 7230, section 6.1).
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// copyHeaders proxies client headers to request for external resource.
+// Every header value is sent as separate header line.
+func copyHeaders(req *http.Request, headers map[string][]string) {
+	skip := make(map[string]bool, len(hopHeaders))
+	for _, key := range hopHeaders {
+		skip[key] = true
+	}
+
+	// Headers listed in Connection header are hop-by-hop too
+	for key, values := range headers {
+		if http.CanonicalHeaderKey(key) != "Connection" {
+			continue
+		}
+		for _, value := range values {
+			for _, field := range strings.Split(value, ",") {
+				if field = textproto.TrimString(field); len(field) > 0 {
+					skip[http.CanonicalHeaderKey(field)] = true
+				}
+			}
+		}
+	}
+
+	for key, values := range headers {
+		key = http.CanonicalHeaderKey(key)
+		switch {
+		case skip[key]:
+			continue
+		case key == "Host":
+			// Go HTTP client ignores Host in header map
+			if len(values) > 0 {
+				req.Host = values[0]
+			}
+		default:
+			for _, value := range values {
+				req.Header.Add(key, value)
+			}
+		}
+	}
+}
+
+// readBody reads whole body and returns its length.
+// Up to limit bytes of body are captured if requested.
+// Length and body read before transfer error are returned with it.
+func readBody(r io.Reader, capture bool, limit int64) (length int64, body []byte, truncated bool, err error) {
+	if capture {
+		buff := &bytes.Buffer{}
+		length, err = io.CopyN(buff, r, limit)
+		body = buff.Bytes()
+		if err != nil && err != io.EOF {
+			return length, body, false, err
+		}
+	}
+
+	// Skip the rest of body
+	rest, err := io.Copy(ioutil.Discard, r)
+	return length + rest, body, capture && rest > 0, err
 }
@@ -1,77 +1,199 @@
 package fetcher
 
 import (
-	"bytes"
-	"io"
+	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
-	"strings"
+	"net/http/httptrace"
 	"time"
 
 	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultMaxBodySize limits captured response body when no limit given.
+	DefaultMaxBodySize = 1 << 20
+	// DefaultMaxRedirects limits followed redirects when no limit given.
+	DefaultMaxRedirects = 10
 )
 
 // HTTPFetcher for external resource implements Fetcher interface.
 type HTTPFetcher struct {
-	client *http.Client
+	timeout     time.Duration
+	maxBodySize int64
+	methods     map[string]bool
+	policy      *EgressPolicy
+
+	// Transports are shared by per request clients to reuse connections,
+	// insecure one is nil unless skipping TLS verification is allowed
+	transport         *http.Transport
+	insecureTransport *http.Transport
 }
 
 // NewHTTPFetcher constructor.
-func NewHTTPFetcher(timeout time.Duration) Fetcher {
-	return &HTTPFetcher{client: &http.Client{
-		Timeout: timeout,
-	}}
+// Empty methods list allows DefaultMethods, nil policy allows any external resource.
+// Timeout limits requested ones, clients may skip TLS verification only if allowInsecure is set.
+func NewHTTPFetcher(timeout time.Duration, maxBodySize int64, methods []string, policy *EgressPolicy,
+	allowInsecure bool) Fetcher {
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if policy != nil {
+		// Check resolved address of every connection
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+			Control:   policy.control,
+		}
+		transport.DialContext = dialer.DialContext
+		// Proxy would hide external resource address from the policy
+		transport.Proxy = nil
+	}
+	var insecureTransport *http.Transport
+	if allowInsecure {
+		insecureTransport = transport.Clone()
+		insecureTransport.TLSClientConfig = &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec // allowed by operator and requested explicitly by client
+		}
+	}
+
+	return &HTTPFetcher{
+		timeout:           timeout,
+		maxBodySize:       maxBodySize,
+		methods:           newMethodSet(methods),
+		policy:            policy,
+		transport:         transport,
+		insecureTransport: insecureTransport,
+	}
+}
+
+// client creates HTTP client configured by request options.
+// Redirects followed by client are appended to chain.
+func (f *HTTPFetcher) client(opts *model.FetchOptions, chain *[]model.Redirect) *http.Client {
+	transport := f.transport
+	if opts.InsecureSkipVerify {
+		transport = f.insecureTransport
+	}
+
+	// Requested timeout may only shorten server one
+	timeout := f.timeout
+	if requested := time.Duration(opts.TimeoutMS) * time.Millisecond; requested > 0 && (timeout <= 0 || requested < timeout) {
+		timeout = requested
+	}
+
+	follow := opts.FollowRedirects == nil || *opts.FollowRedirects
+	maxRedirects := DefaultMaxRedirects
+	if opts.MaxRedirects > 0 {
+		maxRedirects = opts.MaxRedirects
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if !follow {
+				// Return redirect response as final one
+				return http.ErrUseLastResponse
+			}
+			if len(via) > maxRedirects {
+				return errors.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if f.policy != nil {
+				if err := f.policy.checkScheme(req.URL); err != nil {
+					return err
+				}
+			}
+			*chain = append(*chain, model.Redirect{
+				URL:      via[len(via)-1].URL.String(),
+				Status:   req.Response.StatusCode,
+				Location: req.URL.String(),
+			})
+			return nil
+		},
+	}
 }
 
 // Fetch data from external resource.
-func (f *HTTPFetcher) Fetch(id string, data *model.FetchData) (*model.Response, error) {
-	if err := checkFetchData(data); err != nil {
+func (f *HTTPFetcher) Fetch(ctx context.Context, id string, data *model.FetchData) (*model.Response, error) {
+	if err := checkFetchData(data, f.methods); err != nil {
 		return nil, err
 	}
+	if data.InsecureSkipVerify && f.insecureTransport == nil {
+		return nil, ErrInsecureNotAllowed
+	}
 
 	// Create HTTP request to external resource
-	var body io.Reader
-	if len(data.Body) > 0 {
-		body = bytes.NewReader([]byte(data.Body))
+	body, contentType, err := newRequestBody(data)
+	if err != nil {
+		return nil, err
 	}
-	req, err := http.NewRequest(data.Method, data.URL, body)
+	req, err := http.NewRequestWithContext(ctx, data.Method, data.URL, body)
 	if err != nil {
 		return nil, ErrCreatingHTTPRequest
 	}
 
+	// Check scheme of external resource URL
+	if f.policy != nil {
+		if err := f.policy.checkScheme(req.URL); err != nil {
+			return &model.Response{
+				ID:    id,
+				Error: newFetchError(err),
+			}, nil
+		}
+	}
+
 	// Proxying HTTP headers to request
-	for key, value := range data.Headers {
-		req.Header.Add(key, strings.Join(value, " "))
+	copyHeaders(req, data.Headers)
+
+	// Set content type of encoded body, multipart boundary must always match it
+	if len(contentType) > 0 &&
+		(data.BodyEncoding == model.BodyEncodingMultipart || len(req.Header.Get("Content-Type")) == 0) {
+		req.Header.Set("Content-Type", contentType)
 	}
 
-	// Make request to external resource
-	resp, err := f.client.Do(req)
-	if err != nil || resp == nil {
-		// Process error from external resource
-		statusCode := http.StatusInternalServerError
-		if resp != nil {
-			statusCode = resp.StatusCode
-		}
+	// Make request to external resource tracing its phases
+	tr := newTracer()
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), tr.clientTrace()))
+	var redirects []model.Redirect
+	resp, err := f.client(&data.FetchOptions, &redirects).Do(req)
+	if err != nil {
+		// No HTTP response from external resource, so status is left zero
 		return &model.Response{
-			ID:      id,
-			Status:  statusCode,
-			Headers: nil,
-			Length:  0,
+			ID:        id,
+			Error:     newFetchError(err),
+			Redirects: redirects,
+			Timing:    tr.done(),
 		}, nil
 	}
 	defer func() {
 		_ = resp.Body.Close()
 	}()
 
-	length := resp.ContentLength
-	if length < 0 {
-		length = 0
+	// Read whole body to get real length and capture it if requested
+	limit := f.maxBodySize
+	if data.MaxBodySize > 0 && data.MaxBodySize < limit {
+		limit = data.MaxBodySize
 	}
+	length, captured, truncated, err := readBody(resp.Body, data.CaptureBody, limit)
 
 	// Get info from valid response
-	return &model.Response{
-		ID:      id,
-		Status:  resp.StatusCode,
-		Headers: resp.Header,
-		Length:  length,
-	}, nil
+	result := &model.Response{
+		ID:        id,
+		Status:    resp.StatusCode,
+		Headers:   resp.Header,
+		Length:    length,
+		Body:      captured,
+		Truncated: truncated,
+		Redirects: redirects,
+		Timing:    tr.done(),
+	}
+	if err != nil {
+		// Body transfer was interrupted
+		result.Error = newFetchError(err)
+	}
+	return result, nil
 }
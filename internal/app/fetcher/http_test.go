@@ -0,0 +1,594 @@
+package fetcher_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHTTPFetcher_FetchBody(t *testing.T) {
+	content := strings.Repeat("0123456789", 10)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Flushing makes chunked response without Content-Length
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		_, _ = w.Write([]byte(content))
+	}))
+	defer ts.Close()
+
+	testCases := []struct {
+		name        string
+		limit       int64
+		captureBody bool
+		maxBodySize int64
+		body        []byte
+		truncated   bool
+	}{
+		{
+			name:        "Body is not captured",
+			limit:       1000,
+			captureBody: false,
+			maxBodySize: 0,
+			body:        nil,
+			truncated:   false,
+		},
+		{
+			name:        "Whole body",
+			limit:       1000,
+			captureBody: true,
+			maxBodySize: 0,
+			body:        []byte(content),
+			truncated:   false,
+		},
+		{
+			name:        "Exact size body",
+			limit:       1000,
+			captureBody: true,
+			maxBodySize: int64(len(content)),
+			body:        []byte(content),
+			truncated:   false,
+		},
+		{
+			name:        "Truncated body",
+			limit:       1000,
+			captureBody: true,
+			maxBodySize: 10,
+			body:        []byte(content[:10]),
+			truncated:   true,
+		},
+		{
+			name:        "Request limit above fetcher limit",
+			limit:       20,
+			captureBody: true,
+			maxBodySize: 1 << 30,
+			body:        []byte(content[:20]),
+			truncated:   true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := fetcher.NewHTTPFetcher(time.Second, tc.limit, nil, nil, false)
+			resp, err := f.Fetch(context.Background(), "id", &model.FetchData{
+				Method: http.MethodGet,
+				URL:    ts.URL,
+				FetchOptions: model.FetchOptions{
+					CaptureBody: tc.captureBody,
+					MaxBodySize: tc.maxBodySize,
+				},
+			})
+			require.Nil(t, err)
+			require.Equal(t, http.StatusOK, resp.Status)
+			require.Equal(t, int64(len(content)), resp.Length)
+			require.Equal(t, tc.body, resp.Body)
+			require.Equal(t, tc.truncated, resp.Truncated)
+		})
+	}
+}
+
+func TestHTTPFetcher_FetchErrors(t *testing.T) {
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer slow.Close()
+
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer secure.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failing.Close()
+
+	// Get address without listener
+	closed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	closed.Close()
+
+	testCases := []struct {
+		name   string
+		url    string
+		status int
+		kind   model.ErrorKind
+	}{
+		{
+			name:   "DNS failure",
+			url:    "http://nonexistent.invalid",
+			status: 0,
+			kind:   model.ErrorKindDNS,
+		},
+		{
+			name:   "Connection refused",
+			url:    closed.URL,
+			status: 0,
+			kind:   model.ErrorKindConnect,
+		},
+		{
+			name:   "Timeout",
+			url:    slow.URL,
+			status: 0,
+			kind:   model.ErrorKindTimeout,
+		},
+		{
+			name:   "Untrusted certificate",
+			url:    secure.URL,
+			status: 0,
+			kind:   model.ErrorKindTLS,
+		},
+		{
+			name:   "Upstream error",
+			url:    failing.URL,
+			status: http.StatusInternalServerError,
+			kind:   "",
+		},
+	}
+
+	f := fetcher.NewHTTPFetcher(500*time.Millisecond, 0, nil, nil, false)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := f.Fetch(context.Background(), "id", &model.FetchData{
+				Method: http.MethodGet,
+				URL:    tc.url,
+			})
+			require.Nil(t, err)
+			require.Equal(t, tc.status, resp.Status)
+			if len(tc.kind) == 0 {
+				require.Nil(t, resp.Error)
+				return
+			}
+			require.NotNil(t, resp.Error)
+			require.Equal(t, tc.kind, resp.Error.Kind)
+			require.NotEmpty(t, resp.Error.Message)
+		})
+	}
+}
+
+func TestHTTPFetcher_FetchOptions(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/redirect/2", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/redirect/1", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/redirect/1", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/final", http.StatusFound)
+	})
+	mux.HandleFunc("/final", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	})
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	secure := httptest.NewTLSServer(mux)
+	defer secure.Close()
+
+	follow, noFollow := true, false
+	testCases := []struct {
+		name      string
+		url       string
+		options   model.FetchOptions
+		status    int
+		kind      model.ErrorKind
+		redirects int
+	}{
+		{
+			name:      "Follow redirects",
+			url:       ts.URL + "/redirect/2",
+			options:   model.FetchOptions{FollowRedirects: &follow},
+			status:    http.StatusOK,
+			redirects: 2,
+		},
+		{
+			name:      "Do not follow redirects",
+			url:       ts.URL + "/redirect/2",
+			options:   model.FetchOptions{FollowRedirects: &noFollow},
+			status:    http.StatusMovedPermanently,
+			redirects: 0,
+		},
+		{
+			name:      "Too many redirects",
+			url:       ts.URL + "/redirect/2",
+			options:   model.FetchOptions{MaxRedirects: 1},
+			kind:      model.ErrorKindOther,
+			redirects: 1,
+		},
+		{
+			name:    "Request timeout",
+			url:     ts.URL + "/slow",
+			options: model.FetchOptions{TimeoutMS: 50},
+			kind:    model.ErrorKindTimeout,
+		},
+		{
+			name:    "Untrusted certificate",
+			url:     secure.URL + "/final",
+			options: model.FetchOptions{},
+			kind:    model.ErrorKindTLS,
+		},
+		{
+			name:    "Insecure skip verify",
+			url:     secure.URL + "/final",
+			options: model.FetchOptions{InsecureSkipVerify: true},
+			status:  http.StatusOK,
+		},
+	}
+
+	f := fetcher.NewHTTPFetcher(5*time.Second, 0, nil, nil, true)
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := f.Fetch(context.Background(), "id", &model.FetchData{
+				Method:       http.MethodGet,
+				URL:          tc.url,
+				FetchOptions: tc.options,
+			})
+			require.Nil(t, err)
+			require.Equal(t, tc.status, resp.Status)
+			require.Equal(t, tc.redirects, len(resp.Redirects))
+			if len(tc.kind) == 0 {
+				require.Nil(t, resp.Error)
+			} else {
+				require.NotNil(t, resp.Error)
+				require.Equal(t, tc.kind, resp.Error.Kind)
+			}
+		})
+	}
+
+	// Check recorded redirect chain
+	resp, err := f.Fetch(context.Background(), "id", &model.FetchData{
+		Method: http.MethodGet,
+		URL:    ts.URL + "/redirect/2",
+	})
+	require.Nil(t, err)
+	require.Equal(t, []model.Redirect{
+		{URL: ts.URL + "/redirect/2", Status: http.StatusMovedPermanently, Location: ts.URL + "/redirect/1"},
+		{URL: ts.URL + "/redirect/1", Status: http.StatusFound, Location: ts.URL + "/final"},
+	}, resp.Redirects)
+}
+
+func TestHTTPFetcher_ServerLimits(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer ts.Close()
+	secure := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer secure.Close()
+
+	// Requested timeout longer than server one is limited by it
+	f := fetcher.NewHTTPFetcher(50*time.Millisecond, 0, nil, nil, false)
+	resp, err := f.Fetch(context.Background(), "id", &model.FetchData{
+		Method:       http.MethodGet,
+		URL:          ts.URL,
+		FetchOptions: model.FetchOptions{TimeoutMS: int64(time.Hour / time.Millisecond)},
+	})
+	require.Nil(t, err)
+	require.NotNil(t, resp.Error)
+	require.Equal(t, model.ErrorKindTimeout, resp.Error.Kind)
+
+	// Skipping TLS verification is rejected unless allowed by server
+	_, err = f.Fetch(context.Background(), "id", &model.FetchData{
+		Method:       http.MethodGet,
+		URL:          secure.URL,
+		FetchOptions: model.FetchOptions{InsecureSkipVerify: true},
+	})
+	require.Equal(t, fetcher.ErrInsecureNotAllowed, err)
+}
+
+func TestHTTPFetcher_FetchMethods(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Method", r.Method)
+	}))
+	defer ts.Close()
+
+	testCases := []struct {
+		name    string
+		allowed []string
+		method  string
+		err     error
+	}{
+		{name: "Default PUT", allowed: nil, method: http.MethodPut, err: nil},
+		{name: "Default PATCH", allowed: nil, method: http.MethodPatch, err: nil},
+		{name: "Default HEAD", allowed: nil, method: http.MethodHead, err: nil},
+		{name: "Default OPTIONS", allowed: nil, method: http.MethodOptions, err: nil},
+		{name: "Non-standard method", allowed: nil, method: "FETCH", err: fetcher.ErrWrongHTTPMethod},
+		{name: "Default CONNECT", allowed: nil, method: http.MethodConnect, err: fetcher.ErrWrongHTTPMethod},
+		{name: "Default TRACE", allowed: nil, method: http.MethodTrace, err: fetcher.ErrWrongHTTPMethod},
+		{name: "Explicit TRACE", allowed: []string{"GET", "TRACE"}, method: http.MethodTrace, err: nil},
+		{name: "Allowed method", allowed: []string{"get", " post"}, method: http.MethodPost, err: nil},
+		{name: "Disallowed method", allowed: []string{"get", " post"}, method: http.MethodPut, err: fetcher.ErrWrongHTTPMethod},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			f := fetcher.NewHTTPFetcher(time.Second, 0, tc.allowed, nil, false)
+			resp, err := f.Fetch(context.Background(), "id", &model.FetchData{
+				Method: tc.method,
+				URL:    ts.URL,
+			})
+			require.Equal(t, tc.err, err)
+			if tc.err == nil {
+				require.Equal(t, http.StatusOK, resp.Status)
+				require.Equal(t, []string{tc.method}, resp.Headers["X-Method"])
+			}
+		})
+	}
+}
+
+func TestHTTPFetcher_FetchBodyEncoding(t *testing.T) {
+	// Echo request content type and body
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type", r.Header.Get("Content-Type"))
+		_, _ = io.Copy(w, r.Body)
+	}))
+	defer ts.Close()
+
+	f := fetcher.NewHTTPFetcher(time.Second, 0, nil, nil, false)
+	fetch := func(data *model.FetchData) (*model.Response, error) {
+		data.Method = http.MethodPost
+		data.URL = ts.URL
+		data.CaptureBody = true
+		return f.Fetch(context.Background(), "id", data)
+	}
+
+	t.Run("Plain text", func(t *testing.T) {
+		resp, err := fetch(&model.FetchData{Body: "plain text"})
+		require.Nil(t, err)
+		require.Equal(t, []byte("plain text"), resp.Body)
+	})
+
+	t.Run("Base64 binary", func(t *testing.T) {
+		resp, err := fetch(&model.FetchData{
+			Body:         base64.StdEncoding.EncodeToString([]byte{0, 1, 2, 255}),
+			BodyEncoding: model.BodyEncodingBase64,
+		})
+		require.Nil(t, err)
+		require.Equal(t, []byte{0, 1, 2, 255}, resp.Body)
+	})
+
+	t.Run("Invalid base64", func(t *testing.T) {
+		_, err := fetch(&model.FetchData{
+			Body:         "not base64!",
+			BodyEncoding: model.BodyEncodingBase64,
+		})
+		require.Equal(t, fetcher.ErrInvalidBody, err)
+	})
+
+	t.Run("Unknown encoding", func(t *testing.T) {
+		_, err := fetch(&model.FetchData{BodyEncoding: "gzip"})
+		require.Equal(t, fetcher.ErrInvalidBody, err)
+	})
+
+	t.Run("URL encoded form", func(t *testing.T) {
+		resp, err := fetch(&model.FetchData{
+			BodyEncoding: model.BodyEncodingForm,
+			Form:         map[string][]string{"a": {"1", "2"}, "b": {"x y"}},
+		})
+		require.Nil(t, err)
+		require.Equal(t, "application/x-www-form-urlencoded", http.Header(resp.Headers).Get("X-Content-Type"))
+		require.Equal(t, []byte("a=1&a=2&b=x+y"), resp.Body)
+	})
+
+	t.Run("Multipart form", func(t *testing.T) {
+		resp, err := fetch(&model.FetchData{
+			Headers:      map[string][]string{"Content-Type": {"text/plain"}},
+			BodyEncoding: model.BodyEncodingMultipart,
+			Parts: []model.Part{
+				{Name: "field", Body: "value"},
+				{
+					Name:        "file",
+					FileName:    "data.bin",
+					ContentType: "application/x-binary",
+					Body:        base64.StdEncoding.EncodeToString([]byte{0, 255}),
+					Base64:      true,
+				},
+			},
+		})
+		require.Nil(t, err)
+
+		// Parse echoed multipart body
+		contentType := http.Header(resp.Headers).Get("X-Content-Type")
+		mediaType, params, err := mime.ParseMediaType(contentType)
+		require.Nil(t, err)
+		require.Equal(t, "multipart/form-data", mediaType)
+		reader := multipart.NewReader(bytes.NewReader(resp.Body), params["boundary"])
+
+		part, err := reader.NextPart()
+		require.Nil(t, err)
+		require.Equal(t, "field", part.FormName())
+		content, err := ioutil.ReadAll(part)
+		require.Nil(t, err)
+		require.Equal(t, []byte("value"), content)
+
+		part, err = reader.NextPart()
+		require.Nil(t, err)
+		require.Equal(t, "file", part.FormName())
+		require.Equal(t, "data.bin", part.FileName())
+		require.Equal(t, "application/x-binary", part.Header.Get("Content-Type"))
+		content, err = ioutil.ReadAll(part)
+		require.Nil(t, err)
+		require.Equal(t, []byte{0, 255}, content)
+
+		_, err = reader.NextPart()
+		require.Equal(t, io.EOF, err)
+	})
+
+	t.Run("Multipart part without name", func(t *testing.T) {
+		_, err := fetch(&model.FetchData{
+			BodyEncoding: model.BodyEncodingMultipart,
+			Parts:        []model.Part{{Body: "value"}},
+		})
+		require.Equal(t, fetcher.ErrInvalidBody, err)
+	})
+}
+
+// wireListener records raw bytes received by accepted connections.
+type wireListener struct {
+	net.Listener
+	mx   sync.Mutex
+	wire bytes.Buffer
+}
+
+func (l *wireListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &wireConn{Conn: conn, listener: l}, nil
+}
+
+func (l *wireListener) String() string {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return l.wire.String()
+}
+
+type wireConn struct {
+	net.Conn
+	listener *wireListener
+}
+
+func (c *wireConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.listener.mx.Lock()
+	c.listener.wire.Write(b[:n])
+	c.listener.mx.Unlock()
+	return n, err
+}
+
+func TestHTTPFetcher_FetchHeaders(t *testing.T) {
+	var received *http.Request
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+	}))
+	listener := &wireListener{Listener: ts.Listener}
+	ts.Listener = listener
+	ts.Start()
+	defer ts.Close()
+
+	f := fetcher.NewHTTPFetcher(time.Second, 0, nil, nil, false)
+	resp, err := f.Fetch(context.Background(), "id", &model.FetchData{
+		Method: http.MethodGet,
+		URL:    ts.URL,
+		Headers: map[string][]string{
+			"Accept":            {"text/html", "application/json"},
+			"cookie":            {"a=1", "b=2"},
+			"X-Signature":       {"sig value"},
+			"Host":              {"example.com"},
+			"Connection":        {"keep-alive, X-Hop"},
+			"X-Hop":             {"hop"},
+			"Keep-Alive":        {"timeout=5"},
+			"Proxy-Connection":  {"keep-alive"},
+			"Transfer-Encoding": {"chunked"},
+			"Upgrade":           {"websocket"},
+		},
+	})
+	require.Nil(t, err)
+	require.Equal(t, http.StatusOK, resp.Status)
+
+	// Check parsed headers
+	require.Equal(t, "example.com", received.Host)
+	require.Equal(t, []string{"text/html", "application/json"}, received.Header["Accept"])
+	require.Equal(t, []string{"a=1", "b=2"}, received.Header["Cookie"])
+	require.Equal(t, []string{"sig value"}, received.Header["X-Signature"])
+	for _, key := range []string{"X-Hop", "Keep-Alive", "Proxy-Connection", "Transfer-Encoding", "Upgrade"} {
+		require.NotContains(t, received.Header, key)
+	}
+
+	// Check exact header lines on the wire
+	wire := listener.String()
+	require.Contains(t, wire, "\r\nHost: example.com\r\n")
+	require.Contains(t, wire, "\r\nAccept: text/html\r\nAccept: application/json\r\n")
+	require.Contains(t, wire, "\r\nCookie: a=1\r\nCookie: b=2\r\n")
+	require.Contains(t, wire, "\r\nX-Signature: sig value\r\n")
+	require.NotContains(t, wire, "X-Hop")
+	require.NotContains(t, wire, "websocket")
+	require.NotContains(t, wire, "chunked")
+}
+
+func TestHTTPFetcher_FetchTiming(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(20 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(20 * time.Millisecond)
+		_, _ = w.Write([]byte("body"))
+	})
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+
+	f := fetcher.NewHTTPFetcher(time.Second, 0, nil, nil, true)
+	fetch := func(url string) *model.Timing {
+		resp, err := f.Fetch(context.Background(), "id", &model.FetchData{
+			Method:       http.MethodGet,
+			URL:          url,
+			FetchOptions: model.FetchOptions{InsecureSkipVerify: true},
+		})
+		require.Nil(t, err)
+		require.Nil(t, resp.Error)
+		require.NotNil(t, resp.Timing)
+		return resp.Timing
+	}
+
+	// New connection
+	timing := fetch(ts.URL)
+	require.Equal(t, ts.Listener.Addr().String(), timing.RemoteAddr)
+	require.False(t, timing.Reused)
+	require.True(t, timing.ConnectMS > 0, "connect %v", timing.ConnectMS)
+	require.Zero(t, timing.TLSMS)
+	require.True(t, timing.FirstByteMS >= 20, "first byte %v", timing.FirstByteMS)
+	require.True(t, timing.TransferMS >= 20, "transfer %v", timing.TransferMS)
+	require.True(t, timing.TotalMS >= timing.FirstByteMS+timing.TransferMS, "total %v", timing.TotalMS)
+
+	// Connection is reused from pool
+	timing = fetch(ts.URL)
+	require.True(t, timing.Reused)
+	require.Zero(t, timing.ConnectMS)
+
+	// TLS handshake
+	timing = fetch(secure.URL)
+	require.Equal(t, secure.Listener.Addr().String(), timing.RemoteAddr)
+	require.True(t, timing.TLSMS > 0, "tls %v", timing.TLSMS)
+
+	// Timing of failed request
+	closed := httptest.NewServer(handler)
+	closed.Close()
+	resp, err := f.Fetch(context.Background(), "id", &model.FetchData{Method: http.MethodGet, URL: closed.URL})
+	require.Nil(t, err)
+	require.NotNil(t, resp.Error)
+	require.NotNil(t, resp.Timing)
+	require.Zero(t, resp.Timing.FirstByteMS)
+	require.True(t, resp.Timing.TotalMS > 0)
+}
@@ -1,21 +1,24 @@
 package fetcher
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/ahamtat/itvbackend/internal/app/model"
 )
 
-type MockFetcher struct{}
+type MockFetcher struct {
+	methods map[string]bool
+}
 
 // NewMockFetcher constructor.
 func NewMockFetcher() Fetcher {
-	return &MockFetcher{}
+	return &MockFetcher{methods: newMethodSet(nil)}
 }
 
 // Fetch data from mock resource.
-func (f *MockFetcher) Fetch(id string, data *model.FetchData) (*model.Response, error) {
-	if err := checkFetchData(data); err != nil {
+func (f *MockFetcher) Fetch(ctx context.Context, id string, data *model.FetchData) (*model.Response, error) {
+	if err := checkFetchData(data, f.methods); err != nil {
 		return nil, err
 	}
 
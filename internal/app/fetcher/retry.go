@@ -0,0 +1,214 @@
+package fetcher
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ahamtat/itvbackend/internal/app/model"
+)
+
+// DefaultRetryPolicy makes up to three attempts, requests could lower the number.
+var DefaultRetryPolicy = model.RetryPolicy{
+	MaxAttempts:   3,
+	BackoffBaseMS: 100,
+	BackoffCapMS:  10000,
+	RetryStatuses: []int{
+		http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout,
+	},
+	RetryErrors: []model.ErrorKind{
+		model.ErrorKindConnect,
+		model.ErrorKindTimeout,
+	},
+}
+
+// RetryFetcher decorates Fetcher with retries of failed attempts.
+type RetryFetcher struct {
+	fetcher Fetcher
+	policy  model.RetryPolicy
+}
+
+// NewRetryFetcher constructor.
+// Default policy is used for fields absent in server policy. Server policy is used
+// for fields absent in request retry policy and limits requested attempts and backoff.
+func NewRetryFetcher(fetcher Fetcher, policy model.RetryPolicy) Fetcher {
+	return &RetryFetcher{
+		fetcher: fetcher,
+		policy:  mergePolicy(&DefaultRetryPolicy, &policy),
+	}
+}
+
+// Fetch data from external resource with retries.
+func (f *RetryFetcher) Fetch(ctx context.Context, id string, data *model.FetchData) (*model.Response, error) {
+	if data == nil {
+		return nil, ErrInvalidInputData
+	}
+	policy := limitPolicy(&f.policy, mergePolicy(&f.policy, data.Retry))
+
+	attempts := make([]model.Attempt, 0, policy.MaxAttempts)
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		resp, err := f.fetcher.Fetch(ctx, id, data)
+		if err != nil {
+			// Invalid input data could not be fixed by retry
+			return nil, err
+		}
+		attempts = append(attempts, model.Attempt{
+			Status:     resp.Status,
+			Error:      resp.Error,
+			DurationMS: time.Since(start).Milliseconds(),
+		})
+
+		// Response of last attempt is returned if backoff is interrupted
+		if attempt >= policy.MaxAttempts || !retryable(&policy, data.Method, resp) ||
+			!sleep(ctx, backoff(&policy, attempt, resp)) {
+			resp.Attempts = attempts
+			return resp, nil
+		}
+	}
+}
+
+// sleep waits for delay, it returns false if context is done first.
+func sleep(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// mergePolicy overrides fields of base policy with non-zero fields of other one.
+func mergePolicy(base, other *model.RetryPolicy) model.RetryPolicy {
+	result := *base
+	if other == nil {
+		return result
+	}
+	if other.MaxAttempts > 0 {
+		result.MaxAttempts = other.MaxAttempts
+	}
+	if other.BackoffBaseMS > 0 {
+		result.BackoffBaseMS = other.BackoffBaseMS
+	}
+	if other.BackoffCapMS > 0 {
+		result.BackoffCapMS = other.BackoffCapMS
+	}
+	if other.Jitter != nil {
+		result.Jitter = other.Jitter
+	}
+	if other.RetryStatuses != nil {
+		result.RetryStatuses = other.RetryStatuses
+	}
+	if other.RetryErrors != nil {
+		result.RetryErrors = other.RetryErrors
+	}
+	if other.RespectRetryAfter != nil {
+		result.RespectRetryAfter = other.RespectRetryAfter
+	}
+	if other.RetryNonIdempotent {
+		result.RetryNonIdempotent = true
+	}
+	return result
+}
+
+// limitPolicy limits attempts and backoff delays of request policy by server one,
+// so single request could not occupy worker longer than server allows.
+func limitPolicy(server *model.RetryPolicy, policy model.RetryPolicy) model.RetryPolicy {
+	if policy.MaxAttempts > server.MaxAttempts {
+		policy.MaxAttempts = server.MaxAttempts
+	}
+	if policy.BackoffCapMS > server.BackoffCapMS {
+		policy.BackoffCapMS = server.BackoffCapMS
+	}
+	if policy.BackoffBaseMS > policy.BackoffCapMS {
+		policy.BackoffBaseMS = policy.BackoffCapMS
+	}
+	return policy
+}
+
+// retryable reports whether failed attempt could be repeated. Non-idempotent
+// methods are repeated only if request policy allows it explicitly.
+func retryable(policy *model.RetryPolicy, method string, resp *model.Response) bool {
+	if !policy.RetryNonIdempotent && !idempotent(method) {
+		return false
+	}
+	if resp.Error != nil {
+		for _, kind := range policy.RetryErrors {
+			if resp.Error.Kind == kind {
+				return true
+			}
+		}
+		return false
+	}
+	for _, status := range policy.RetryStatuses {
+		if resp.Status == status {
+			return true
+		}
+	}
+	return false
+}
+
+// idempotent reports whether repeated requests with method have the same effect as single one.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodPost, http.MethodPatch, http.MethodConnect:
+		return false
+	}
+	return true
+}
+
+// backoff computes delay after failed attempt.
+func backoff(policy *model.RetryPolicy, attempt int, resp *model.Response) time.Duration {
+	limit := time.Duration(policy.BackoffCapMS) * time.Millisecond
+
+	// Delay requested by external resource
+	if policy.RespectRetryAfter == nil || *policy.RespectRetryAfter {
+		if delay, ok := retryAfter(resp); ok {
+			if delay > limit {
+				delay = limit
+			}
+			return delay
+		}
+	}
+
+	// Exponential delay is limited by cap
+	delay := time.Duration(policy.BackoffBaseMS) * time.Millisecond
+	for i := 1; i < attempt && delay < limit; i++ {
+		delay *= 2
+	}
+	if delay > limit {
+		delay = limit
+	}
+
+	// Full jitter spreads retries of concurrent requests
+	if policy.Jitter == nil || *policy.Jitter {
+		delay = time.Duration(rand.Int63n(int64(delay) + 1)) //nolint:gosec // no need for secure random
+	}
+	return delay
+}
+
+// retryAfter parses Retry-After header in seconds or HTTP date format.
+func retryAfter(resp *model.Response) (time.Duration, bool) {
+	value := http.Header(resp.Headers).Get("Retry-After")
+	if len(value) == 0 {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		delay := time.Until(date)
+		if delay < 0 {
+			delay = 0
+		}
+		return delay, true
+	}
+	return 0, false
+}
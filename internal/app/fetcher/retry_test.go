@@ -0,0 +1,269 @@
+package fetcher_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/stretchr/testify/require"
+)
+
+// scriptFetcher returns prepared responses one by one.
+type scriptFetcher struct {
+	responses []*model.Response
+	calls     int
+}
+
+func (f *scriptFetcher) Fetch(ctx context.Context, id string, data *model.FetchData) (*model.Response, error) {
+	resp := f.responses[f.calls]
+	f.calls++
+	return resp, nil
+}
+
+func TestRetryFetcher_Fetch(t *testing.T) {
+	noJitter := false
+	policy := model.RetryPolicy{
+		MaxAttempts:   3,
+		BackoffBaseMS: 1,
+		BackoffCapMS:  5,
+		Jitter:        &noJitter,
+	}
+	unavailable := &model.Response{Status: http.StatusServiceUnavailable}
+	refused := &model.Response{Error: &model.FetchError{Kind: model.ErrorKindConnect, Message: "connection refused"}}
+	unknownHost := &model.Response{Error: &model.FetchError{Kind: model.ErrorKindDNS, Message: "no such host"}}
+	ok := &model.Response{Status: http.StatusOK}
+
+	testCases := []struct {
+		name      string
+		responses []*model.Response
+		retry     *model.RetryPolicy
+		status    int
+		attempts  []model.Attempt
+	}{
+		{
+			name:      "Success after retries",
+			responses: []*model.Response{unavailable, refused, ok},
+			status:    http.StatusOK,
+			attempts: []model.Attempt{
+				{Status: http.StatusServiceUnavailable},
+				{Error: refused.Error},
+				{Status: http.StatusOK},
+			},
+		},
+		{
+			name:      "Attempts are exhausted",
+			responses: []*model.Response{unavailable, unavailable, unavailable},
+			status:    http.StatusServiceUnavailable,
+			attempts: []model.Attempt{
+				{Status: http.StatusServiceUnavailable},
+				{Status: http.StatusServiceUnavailable},
+				{Status: http.StatusServiceUnavailable},
+			},
+		},
+		{
+			name:      "Non-retryable status",
+			responses: []*model.Response{{Status: http.StatusInternalServerError}},
+			status:    http.StatusInternalServerError,
+			attempts:  []model.Attempt{{Status: http.StatusInternalServerError}},
+		},
+		{
+			name:      "Non-retryable error kind",
+			responses: []*model.Response{unknownHost},
+			status:    0,
+			attempts:  []model.Attempt{{Error: unknownHost.Error}},
+		},
+		{
+			name:      "Request policy override",
+			responses: []*model.Response{unknownHost, {Status: http.StatusInternalServerError}},
+			retry: &model.RetryPolicy{
+				MaxAttempts:   2,
+				RetryErrors:   []model.ErrorKind{model.ErrorKindDNS},
+				RetryStatuses: []int{http.StatusInternalServerError},
+			},
+			status: http.StatusInternalServerError,
+			attempts: []model.Attempt{
+				{Error: unknownHost.Error},
+				{Status: http.StatusInternalServerError},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			script := &scriptFetcher{responses: tc.responses}
+			f := fetcher.NewRetryFetcher(script, policy)
+			resp, err := f.Fetch(context.Background(), "id", &model.FetchData{
+				Method:       http.MethodGet,
+				URL:          "http://google.com",
+				FetchOptions: model.FetchOptions{Retry: tc.retry},
+			})
+			require.Nil(t, err)
+			require.Equal(t, tc.status, resp.Status)
+			require.Equal(t, len(tc.responses), script.calls)
+
+			// Durations are not predictable
+			for i := range resp.Attempts {
+				resp.Attempts[i].DurationMS = 0
+			}
+			require.Equal(t, tc.attempts, resp.Attempts)
+		})
+	}
+}
+
+func TestRetryFetcher_RetryAfter(t *testing.T) {
+	noJitter, respect := false, true
+	limited := &model.Response{
+		Status:  http.StatusTooManyRequests,
+		Headers: map[string][]string{"Retry-After": {"1"}},
+	}
+
+	testCases := []struct {
+		name    string
+		respect bool
+		min     time.Duration
+		max     time.Duration
+	}{
+		{
+			name:    "Retry-After limited by cap",
+			respect: true,
+			min:     50 * time.Millisecond,
+			max:     500 * time.Millisecond,
+		},
+		{
+			name:    "Retry-After ignored",
+			respect: false,
+			min:     0,
+			max:     50 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			respect = tc.respect
+			script := &scriptFetcher{responses: []*model.Response{limited, {Status: http.StatusOK}}}
+			f := fetcher.NewRetryFetcher(script, model.RetryPolicy{
+				MaxAttempts:       2,
+				BackoffBaseMS:     1,
+				BackoffCapMS:      50,
+				Jitter:            &noJitter,
+				RespectRetryAfter: &respect,
+			})
+
+			start := time.Now()
+			resp, err := f.Fetch(context.Background(), "id", &model.FetchData{Method: http.MethodGet, URL: "http://google.com"})
+			elapsed := time.Since(start)
+			require.Nil(t, err)
+			require.Equal(t, http.StatusOK, resp.Status)
+			require.Equal(t, 2, len(resp.Attempts))
+			require.True(t, elapsed >= tc.min, "elapsed %s", elapsed)
+			require.True(t, elapsed < tc.max, "elapsed %s", elapsed)
+		})
+	}
+}
+
+func TestRetryFetcher_ServerLimits(t *testing.T) {
+	noJitter := false
+	unavailable := &model.Response{
+		Status:  http.StatusServiceUnavailable,
+		Headers: map[string][]string{"Retry-After": {"3600"}},
+	}
+	script := &scriptFetcher{responses: []*model.Response{unavailable, unavailable, unavailable}}
+	f := fetcher.NewRetryFetcher(script, model.RetryPolicy{
+		MaxAttempts:   2,
+		BackoffBaseMS: 1,
+		BackoffCapMS:  5,
+		Jitter:        &noJitter,
+	})
+
+	// Requested attempts and backoff are limited by server policy
+	start := time.Now()
+	resp, err := f.Fetch(context.Background(), "id", &model.FetchData{
+		Method: http.MethodGet,
+		URL:    "http://google.com",
+		FetchOptions: model.FetchOptions{Retry: &model.RetryPolicy{
+			MaxAttempts:   1000000,
+			BackoffBaseMS: 3600000,
+			BackoffCapMS:  3600000,
+		}},
+	})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(resp.Attempts))
+	require.Equal(t, 2, script.calls)
+	require.True(t, time.Since(start) < time.Second)
+}
+
+func TestRetryFetcher_Canceled(t *testing.T) {
+	noJitter := false
+	unavailable := &model.Response{Status: http.StatusServiceUnavailable}
+	script := &scriptFetcher{responses: []*model.Response{unavailable, unavailable}}
+	f := fetcher.NewRetryFetcher(script, model.RetryPolicy{
+		MaxAttempts:   2,
+		BackoffBaseMS: 60000,
+		BackoffCapMS:  60000,
+		Jitter:        &noJitter,
+	})
+
+	// Done context interrupts backoff returning response of last attempt
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	resp, err := f.Fetch(ctx, "id", &model.FetchData{Method: http.MethodGet, URL: "http://google.com"})
+	require.Nil(t, err)
+	require.Equal(t, http.StatusServiceUnavailable, resp.Status)
+	require.Equal(t, 1, len(resp.Attempts))
+	require.Equal(t, 1, script.calls)
+	require.True(t, time.Since(start) < time.Second)
+}
+
+func TestRetryFetcher_NonIdempotent(t *testing.T) {
+	unavailable := &model.Response{Status: http.StatusServiceUnavailable}
+	noJitter := false
+
+	testCases := []struct {
+		name   string
+		method string
+		retry  *model.RetryPolicy
+		calls  int
+	}{
+		{name: "GET is retried by default", method: http.MethodGet, calls: 3},
+		{name: "PUT is retried by default", method: http.MethodPut, calls: 3},
+		{name: "POST is not retried by default", method: http.MethodPost, calls: 1},
+		{name: "PATCH is not retried by default", method: http.MethodPatch, calls: 1},
+		{
+			name:   "POST is retried on request",
+			method: http.MethodPost,
+			retry:  &model.RetryPolicy{RetryNonIdempotent: true},
+			calls:  3,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			script := &scriptFetcher{responses: []*model.Response{unavailable, unavailable, unavailable}}
+			policy := fetcher.DefaultRetryPolicy
+			policy.BackoffBaseMS, policy.BackoffCapMS, policy.Jitter = 1, 1, &noJitter
+			f := fetcher.NewRetryFetcher(script, policy)
+			resp, err := f.Fetch(context.Background(), "id", &model.FetchData{
+				Method:       tc.method,
+				URL:          "http://google.com",
+				FetchOptions: model.FetchOptions{Retry: tc.retry},
+			})
+			require.Nil(t, err)
+			require.Equal(t, tc.calls, script.calls)
+			require.Equal(t, tc.calls, len(resp.Attempts))
+		})
+	}
+}
+
+func TestRetryFetcher_InvalidData(t *testing.T) {
+	f := fetcher.NewRetryFetcher(fetcher.NewMockFetcher(), model.RetryPolicy{MaxAttempts: 3})
+
+	_, err := f.Fetch(context.Background(), "id", &model.FetchData{Method: "FETCH", URL: "http://google.com"})
+	require.Equal(t, fetcher.ErrWrongHTTPMethod, err)
+
+	_, err = f.Fetch(context.Background(), "id", nil)
+	require.Equal(t, fetcher.ErrInvalidInputData, err)
+}
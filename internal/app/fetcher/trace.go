@@ -0,0 +1,107 @@
+package fetcher
+
+import (
+	"crypto/tls"
+	"net/http/httptrace"
+	"sync"
+	"time"
+
+	"github.com/ahamtat/itvbackend/internal/app/model"
+)
+
+// tracer collects timing of HTTP request.
+// Hooks could be called concurrently while dialing, so they are synchronized.
+type tracer struct {
+	mx sync.Mutex
+
+	start        time.Time
+	dnsStart     time.Time
+	connectStart time.Time
+	tlsStart     time.Time
+	wroteRequest time.Time
+	firstByte    time.Time
+
+	timing model.Timing
+}
+
+func newTracer() *tracer {
+	return &tracer{start: time.Now()}
+}
+
+// clientTrace returns hooks recording request phases.
+func (t *tracer) clientTrace() *httptrace.ClientTrace {
+	return &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) {
+			t.mx.Lock()
+			defer t.mx.Unlock()
+			t.dnsStart = time.Now()
+		},
+		DNSDone: func(httptrace.DNSDoneInfo) {
+			t.mx.Lock()
+			defer t.mx.Unlock()
+			t.timing.DNSMS += milliseconds(time.Since(t.dnsStart))
+		},
+		ConnectStart: func(network, addr string) {
+			t.mx.Lock()
+			defer t.mx.Unlock()
+			// Several addresses could be dialed in parallel
+			if t.connectStart.IsZero() {
+				t.connectStart = time.Now()
+			}
+		},
+		ConnectDone: func(network, addr string, err error) {
+			t.mx.Lock()
+			defer t.mx.Unlock()
+			if err == nil && !t.connectStart.IsZero() {
+				t.timing.ConnectMS += milliseconds(time.Since(t.connectStart))
+				t.connectStart = time.Time{}
+			}
+		},
+		TLSHandshakeStart: func() {
+			t.mx.Lock()
+			defer t.mx.Unlock()
+			t.tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			t.mx.Lock()
+			defer t.mx.Unlock()
+			t.timing.TLSMS += milliseconds(time.Since(t.tlsStart))
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			t.mx.Lock()
+			defer t.mx.Unlock()
+			t.timing.RemoteAddr = info.Conn.RemoteAddr().String()
+			t.timing.Reused = info.Reused
+		},
+		WroteRequest: func(httptrace.WroteRequestInfo) {
+			t.mx.Lock()
+			defer t.mx.Unlock()
+			t.wroteRequest = time.Now()
+		},
+		GotFirstResponseByte: func() {
+			t.mx.Lock()
+			defer t.mx.Unlock()
+			t.firstByte = time.Now()
+			t.timing.FirstByteMS = milliseconds(t.firstByte.Sub(t.wroteRequest))
+		},
+	}
+}
+
+// done returns timing of finished request.
+func (t *tracer) done() *model.Timing {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	now := time.Now()
+	timing := t.timing
+	if !t.firstByte.IsZero() {
+		timing.TransferMS = milliseconds(now.Sub(t.firstByte))
+	}
+	timing.TotalMS = milliseconds(now.Sub(t.start))
+	return &timing
+}
+
+// milliseconds converts duration keeping microsecond precision.
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
@@ -0,0 +1,285 @@
+package limiter
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+// Limits for single external host.
+type Limits struct {
+	// Requests per second, zero is unlimited
+	Rate float64 `json:"rate"`
+	// Token bucket size, at least one request is allowed
+	Burst int `json:"burst"`
+	// Maximum requests in flight, zero is unlimited
+	MaxInFlight int `json:"maxInFlight"`
+}
+
+// Stats of single external host.
+type Stats struct {
+	Limits
+	// Requests in flight
+	InFlight int `json:"inFlight"`
+	// Requests waiting for limits
+	Waiting int `json:"waiting"`
+	// Total number of admitted requests
+	Admitted int64 `json:"admitted"`
+	// Total and maximum time spent by requests waiting for limits
+	QueueTimeMS    int64 `json:"queueTimeMs"`
+	MaxQueueTimeMS int64 `json:"maxQueueTimeMs"`
+}
+
+// minSweep is number of hosts kept without sweeping idle ones.
+const minSweep = 64
+
+type host struct {
+	bucket *rate.Limiter
+	slots  chan struct{}
+	stats  Stats
+	// Time of last admitted or released request
+	used time.Time
+}
+
+// idle reports whether host has no requests and its token bucket is refilled,
+// so forgetting host does not change its limits.
+func (h *host) idle() bool {
+	if h.stats.InFlight > 0 || h.stats.Waiting > 0 {
+		return false
+	}
+	if h.bucket == nil {
+		return true
+	}
+	refill := time.Duration(float64(h.bucket.Burst()) / float64(h.bucket.Limit()) * float64(time.Second))
+	return time.Since(h.used) >= refill
+}
+
+// HostLimiter limits rate and concurrency of requests per external host.
+// Idle hosts are forgotten as new ones appear, so hosts are kept for active ones only.
+// Nil limiter admits any request immediately.
+type HostLimiter struct {
+	mx        sync.Mutex
+	defaults  Limits
+	overrides map[string]Limits
+	hosts     map[string]*host
+	// Number of hosts triggering sweep of idle ones
+	sweepAt int
+}
+
+// NewHostLimiter constructor.
+// Overrides are keyed by host name and replace default limits.
+func NewHostLimiter(defaults Limits, overrides map[string]Limits) *HostLimiter {
+	normalized := make(map[string]Limits, len(overrides))
+	for name, limits := range overrides {
+		normalized[strings.ToLower(name)] = limits
+	}
+	return &HostLimiter{
+		defaults:  defaults,
+		overrides: normalized,
+		hosts:     make(map[string]*host),
+		sweepAt:   minSweep,
+	}
+}
+
+// ParseOverrides parses comma separated list of host=rate:burst:inflight items.
+// Omitted trailing fields are zero, e.g. "api.example.com=5:10:2,example.org=1".
+func ParseOverrides(value string) (map[string]Limits, error) {
+	result := make(map[string]Limits)
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); len(item) == 0 {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 {
+			return nil, errors.Errorf("invalid host limits %s", item)
+		}
+		fields := strings.Split(parts[1], ":")
+		if len(fields) > 3 {
+			return nil, errors.Errorf("invalid host limits %s", item)
+		}
+
+		limits := Limits{}
+		var err error
+		if limits.Rate, err = strconv.ParseFloat(fields[0], 64); err != nil {
+			return nil, errors.Wrapf(err, "invalid rate of host %s", parts[0])
+		}
+		if len(fields) > 1 {
+			if limits.Burst, err = strconv.Atoi(fields[1]); err != nil {
+				return nil, errors.Wrapf(err, "invalid burst of host %s", parts[0])
+			}
+		}
+		if len(fields) > 2 {
+			if limits.MaxInFlight, err = strconv.Atoi(fields[2]); err != nil {
+				return nil, errors.Wrapf(err, "invalid in flight limit of host %s", parts[0])
+			}
+		}
+		result[parts[0]] = limits
+	}
+	return result, nil
+}
+
+// host returns state of external host creating it on first use.
+// Must be called with mutex locked.
+func (l *HostLimiter) host(name string) *host {
+	if h, ok := l.hosts[name]; ok {
+		return h
+	}
+
+	limits, ok := l.overrides[name]
+	if !ok {
+		limits = l.defaults
+	}
+	l.sweep()
+	h := &host{stats: Stats{Limits: limits}, used: time.Now()}
+	if limits.Rate > 0 {
+		burst := limits.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		h.bucket = rate.NewLimiter(rate.Limit(limits.Rate), burst)
+	}
+	if limits.MaxInFlight > 0 {
+		h.slots = make(chan struct{}, limits.MaxInFlight)
+	}
+	l.hosts[name] = h
+	return h
+}
+
+// sweep forgets idle hosts when number of hosts doubles since previous sweep.
+// Must be called with mutex locked.
+func (l *HostLimiter) sweep() {
+	if len(l.hosts) < l.sweepAt {
+		return
+	}
+	for name, h := range l.hosts {
+		if h.idle() {
+			delete(l.hosts, name)
+		}
+	}
+	l.sweepAt = 2 * len(l.hosts)
+	if l.sweepAt < minSweep {
+		l.sweepAt = minSweep
+	}
+}
+
+// TryAcquire admits request to host if it is not saturated.
+// Requests already waiting for the host are preferred over new ones.
+func (l *HostLimiter) TryAcquire(name string) (release func(), ok bool) {
+	if l == nil {
+		return func() {}, true
+	}
+	l.mx.Lock()
+	defer l.mx.Unlock()
+
+	h := l.host(name)
+	if h.stats.Waiting > 0 {
+		return nil, false
+	}
+	if h.slots != nil {
+		select {
+		case h.slots <- struct{}{}:
+		default:
+			return nil, false
+		}
+	}
+	if h.bucket != nil && !h.bucket.Allow() {
+		if h.slots != nil {
+			<-h.slots
+		}
+		return nil, false
+	}
+	l.admit(h, 0)
+	return l.releaser(h), true
+}
+
+// Acquire waits until host admits request or context is done.
+// Returns time spent waiting for limits.
+func (l *HostLimiter) Acquire(ctx context.Context, name string) (release func(), waited time.Duration, err error) {
+	if l == nil {
+		return func() {}, 0, nil
+	}
+	start := time.Now()
+
+	l.mx.Lock()
+	h := l.host(name)
+	h.stats.Waiting++
+	l.mx.Unlock()
+
+	err = l.wait(ctx, h)
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	h.stats.Waiting--
+	if err != nil {
+		return nil, time.Since(start), err
+	}
+	waited = time.Since(start)
+	l.admit(h, waited)
+	return l.releaser(h), waited, nil
+}
+
+func (l *HostLimiter) wait(ctx context.Context, h *host) error {
+	if h.slots != nil {
+		select {
+		case h.slots <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if h.bucket != nil {
+		if err := h.bucket.Wait(ctx); err != nil {
+			if h.slots != nil {
+				<-h.slots
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// admit updates host statistics, must be called with mutex locked.
+func (l *HostLimiter) admit(h *host, waited time.Duration) {
+	h.used = time.Now()
+	h.stats.InFlight++
+	h.stats.Admitted++
+	ms := waited.Milliseconds()
+	h.stats.QueueTimeMS += ms
+	if ms > h.stats.MaxQueueTimeMS {
+		h.stats.MaxQueueTimeMS = ms
+	}
+}
+
+// releaser returns function freeing in flight slot of host exactly once.
+func (l *HostLimiter) releaser(h *host) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mx.Lock()
+			defer l.mx.Unlock()
+			h.stats.InFlight--
+			h.used = time.Now()
+			if h.slots != nil {
+				<-h.slots
+			}
+		})
+	}
+}
+
+// Stats returns statistics of hosts keyed by host name, idle hosts could be forgotten.
+func (l *HostLimiter) Stats() map[string]Stats {
+	result := make(map[string]Stats)
+	if l == nil {
+		return result
+	}
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	for name, h := range l.hosts {
+		result[name] = h.stats
+	}
+	return result
+}
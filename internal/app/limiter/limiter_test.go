@@ -0,0 +1,124 @@
+package limiter_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/ahamtat/itvbackend/internal/app/limiter"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHostLimiter_InFlight(t *testing.T) {
+	l := limiter.NewHostLimiter(limiter.Limits{MaxInFlight: 1}, nil)
+
+	release, ok := l.TryAcquire("example.com")
+	require.True(t, ok)
+
+	// Host is saturated, other hosts are not
+	_, ok = l.TryAcquire("example.com")
+	require.False(t, ok)
+	other, ok := l.TryAcquire("example.org")
+	require.True(t, ok)
+	other()
+
+	// Waiting request is admitted after release
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		release()
+		release() // released only once
+	}()
+	waiting, waited, err := l.Acquire(context.Background(), "example.com")
+	require.Nil(t, err)
+	require.True(t, waited >= 20*time.Millisecond, "waited %s", waited)
+
+	stats := l.Stats()["example.com"]
+	require.Equal(t, 1, stats.InFlight)
+	require.Equal(t, int64(2), stats.Admitted)
+	require.True(t, stats.MaxQueueTimeMS >= 20)
+	waiting()
+	require.Equal(t, 0, l.Stats()["example.com"].InFlight)
+}
+
+func TestHostLimiter_Rate(t *testing.T) {
+	l := limiter.NewHostLimiter(limiter.Limits{}, map[string]limiter.Limits{
+		"Example.com": {Rate: 20, Burst: 2},
+	})
+
+	// Burst is admitted at once
+	for i := 0; i < 2; i++ {
+		release, ok := l.TryAcquire("example.com")
+		require.True(t, ok)
+		release()
+	}
+	_, ok := l.TryAcquire("example.com")
+	require.False(t, ok)
+
+	_, waited, err := l.Acquire(context.Background(), "example.com")
+	require.Nil(t, err)
+	require.True(t, waited >= 30*time.Millisecond, "waited %s", waited)
+
+	// Waiting is canceled by context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = l.Acquire(ctx, "example.com")
+	require.NotNil(t, err)
+	require.Equal(t, 0, l.Stats()["example.com"].Waiting)
+
+	// Unlimited host
+	for i := 0; i < 10; i++ {
+		_, ok = l.TryAcquire("example.org")
+		require.True(t, ok)
+	}
+}
+
+func TestHostLimiter_Idle(t *testing.T) {
+	l := limiter.NewHostLimiter(limiter.Limits{MaxInFlight: 1}, map[string]limiter.Limits{
+		"limited.com": {Rate: 0.001},
+	})
+
+	// Hosts having requests in flight or empty token bucket are kept
+	busy, ok := l.TryAcquire("busy.com")
+	require.True(t, ok)
+	release, ok := l.TryAcquire("limited.com")
+	require.True(t, ok)
+	release()
+
+	// Idle hosts are forgotten as new ones appear
+	for i := 0; i < 1000; i++ {
+		release, ok := l.TryAcquire(fmt.Sprintf("host-%d.com", i))
+		require.True(t, ok)
+		release()
+	}
+	stats := l.Stats()
+	require.True(t, len(stats) <= 128, "%d hosts are kept", len(stats))
+	require.Equal(t, 1, stats["busy.com"].InFlight)
+	_, ok = l.TryAcquire("busy.com")
+	require.False(t, ok)
+	_, ok = l.TryAcquire("limited.com")
+	require.False(t, ok)
+	busy()
+}
+
+func TestHostLimiter_Nil(t *testing.T) {
+	var l *limiter.HostLimiter
+	release, ok := l.TryAcquire("example.com")
+	require.True(t, ok)
+	release()
+	require.Empty(t, l.Stats())
+}
+
+func TestParseOverrides(t *testing.T) {
+	overrides, err := limiter.ParseOverrides("api.example.com=5:10:2, example.org=0.5,")
+	require.Nil(t, err)
+	require.Equal(t, map[string]limiter.Limits{
+		"api.example.com": {Rate: 5, Burst: 10, MaxInFlight: 2},
+		"example.org":     {Rate: 0.5},
+	}, overrides)
+
+	for _, value := range []string{"example.com", "=1", "example.com=fast", "example.com=1:2:3:4", "example.com=1:x"} {
+		_, err = limiter.ParseOverrides(value)
+		require.NotNil(t, err, value)
+	}
+}
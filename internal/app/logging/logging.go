@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"context"
+
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// callKey is context key of API call data.
+type callKey struct{}
+
+// call holds data of API call logged with every entry.
+type call struct {
+	requestID   string
+	requestUUID string
+}
+
+// NewLogger creates logger writing entries as JSON objects.
+func NewLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetFormatter(&logrus.JSONFormatter{})
+	return logger
+}
+
+// WithCall returns context carrying API call with correlation ID.
+func WithCall(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, callKey{}, &call{requestID: requestID})
+}
+
+// RequestID returns correlation ID from context, it is empty if not set.
+func RequestID(ctx context.Context) string {
+	if c, ok := ctx.Value(callKey{}).(*call); ok {
+		return c.requestID
+	}
+	return ""
+}
+
+// SetRequestUUID remembers ID of stored request handled by API call.
+func SetRequestUUID(ctx context.Context, ID string) {
+	if c, ok := ctx.Value(callKey{}).(*call); ok {
+		c.requestUUID = ID
+	}
+}
+
+// Entry returns log entry with correlation ID, stored request ID and trace ID from context.
+func Entry(logger *logrus.Logger, ctx context.Context) *logrus.Entry {
+	entry := logrus.NewEntry(logger)
+	if c, ok := ctx.Value(callKey{}).(*call); ok {
+		if len(c.requestID) > 0 {
+			entry = entry.WithField("request_id", c.requestID)
+		}
+		if len(c.requestUUID) > 0 {
+			entry = entry.WithField("request_uuid", c.requestUUID)
+		}
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		entry = entry.WithField("trace_id", sc.TraceID().String())
+	}
+	return entry
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/model"
+)
+
+// Fetcher decorates fetcher recording latency of every fetch per external host.
+type Fetcher struct {
+	fetcher fetcher.Fetcher
+	metrics *Metrics
+}
+
+// NewFetcher constructor.
+func NewFetcher(fetcher fetcher.Fetcher, metrics *Metrics) fetcher.Fetcher {
+	return &Fetcher{fetcher: fetcher, metrics: metrics}
+}
+
+// Fetch data from external resource recording its latency.
+// Fetch data rejected by fetcher is not recorded.
+func (f *Fetcher) Fetch(ctx context.Context, id string, data *model.FetchData) (*model.Response, error) {
+	start := time.Now()
+	resp, err := f.fetcher.Fetch(ctx, id, data)
+	if err == nil {
+		f.metrics.ObserveFetch(data.HostName(), time.Since(start))
+	}
+	return resp, err
+}
@@ -0,0 +1,178 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "itvbackend"
+
+// maxHosts limits number of host label values, other hosts are labeled as OTHER.
+const maxHosts = 100
+
+// Metrics of application exposed in OpenMetrics text format.
+// Nil metrics record nothing.
+type Metrics struct {
+	registry  *prometheus.Registry
+	accepted  *prometheus.CounterVec
+	completed *prometheus.CounterVec
+	failed    *prometheus.CounterVec
+	fetch     *prometheus.HistogramVec
+	queueWait prometheus.Histogram
+	storage   *prometheus.HistogramVec
+
+	// Host label values, first hosts are labeled by name
+	hostsMx sync.Mutex
+	hosts   map[string]bool
+}
+
+// NewMetrics constructor.
+// Metrics are registered in own registry along with Go runtime and process collectors.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		hosts:    make(map[string]bool),
+		accepted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_accepted_total",
+			Help:      "Client requests accepted for fetching external resource.",
+		}, []string{"method"}),
+		completed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_completed_total",
+			Help:      "Client requests completed with HTTP response from external resource.",
+		}, []string{"method", "status_class"}),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "requests_failed_total",
+			Help:      "Client requests failed without HTTP response from external resource.",
+		}, []string{"method", "reason"}),
+		fetch: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "fetch_duration_seconds",
+			Help:      "Latency of fetching external resource per host, hosts beyond first ones are labeled as OTHER.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"host"}),
+		queueWait: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "queue_wait_seconds",
+			Help:      "Time spent by tasks waiting for worker and host limits.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+		}),
+		storage: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "storage_operation_duration_seconds",
+			Help:      "Latency of storage operations per backend.",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"backend", "operation"}),
+	}
+	m.registry.MustRegister(
+		m.accepted, m.completed, m.failed, m.fetch, m.queueWait, m.storage,
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves metrics in OpenMetrics or Prometheus text format negotiated by Accept header.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{EnableOpenMetrics: true})
+}
+
+// Gauge registers gauge reading its value from function on every scrape.
+// Gauge already registered under the same name is kept.
+func (m *Metrics) Gauge(name, help string, value func() float64) {
+	if m == nil {
+		return
+	}
+	_ = m.registry.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, value))
+}
+
+// Accepted counts client request accepted for fetching.
+func (m *Metrics) Accepted(method string) {
+	if m == nil {
+		return
+	}
+	m.accepted.WithLabelValues(methodLabel(method)).Inc()
+}
+
+// Completed counts client request completed with HTTP response.
+func (m *Metrics) Completed(method string, status int) {
+	if m == nil {
+		return
+	}
+	m.completed.WithLabelValues(methodLabel(method), statusClass(status)).Inc()
+}
+
+// Failed counts client request failed without HTTP response.
+func (m *Metrics) Failed(method, reason string) {
+	if m == nil {
+		return
+	}
+	m.failed.WithLabelValues(methodLabel(method), reason).Inc()
+}
+
+// ObserveFetch records latency of fetching external resource.
+func (m *Metrics) ObserveFetch(host string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.fetch.WithLabelValues(m.hostLabel(host)).Observe(d.Seconds())
+}
+
+// ObserveQueueWait records time spent by task waiting in queue.
+func (m *Metrics) ObserveQueueWait(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.queueWait.Observe(d.Seconds())
+}
+
+// ObserveStorage records latency of storage operation.
+func (m *Metrics) ObserveStorage(backend, operation string, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.storage.WithLabelValues(backend, operation).Observe(d.Seconds())
+}
+
+// methodLabel limits label values to standard HTTP methods.
+func methodLabel(method string) string {
+	method = strings.ToUpper(method)
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	}
+	return "OTHER"
+}
+
+// hostLabel limits label values to first hosts, so clients could not inflate series.
+func (m *Metrics) hostLabel(host string) string {
+	m.hostsMx.Lock()
+	defer m.hostsMx.Unlock()
+	if !m.hosts[host] {
+		if len(m.hosts) >= maxHosts {
+			return "OTHER"
+		}
+		m.hosts[host] = true
+	}
+	return host
+}
+
+// statusClass returns class of HTTP status code like 2xx.
+func statusClass(status int) string {
+	if status < 100 || status > 599 {
+		return "other"
+	}
+	return string(rune('0'+status/100)) + "xx"
+}
@@ -0,0 +1,107 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/metrics"
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/ahamtat/itvbackend/internal/app/storage/memory"
+)
+
+// scrape reads metrics exposition from handler.
+func scrape(m *metrics.Metrics, t *testing.T) string {
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+	require.Nil(t, err)
+	m.Handler().ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	return rec.Body.String()
+}
+
+func TestMetrics_Counters(t *testing.T) {
+	m := metrics.NewMetrics()
+	m.Accepted("get")
+	m.Accepted("BREW")
+	m.Completed("GET", http.StatusNotFound)
+	m.Failed("GET", "timeout")
+	m.Gauge("queue_depth", "Tasks waiting in task channel.", func() float64 { return 3 })
+	m.Gauge("queue_depth", "Duplicated gauge is ignored.", func() float64 { return 5 })
+
+	body := scrape(m, t)
+	require.Contains(t, body, `itvbackend_requests_accepted_total{method="GET"} 1`)
+	require.Contains(t, body, `itvbackend_requests_accepted_total{method="OTHER"} 1`)
+	require.Contains(t, body, `itvbackend_requests_completed_total{method="GET",status_class="4xx"} 1`)
+	require.Contains(t, body, `itvbackend_requests_failed_total{method="GET",reason="timeout"} 1`)
+	require.Contains(t, body, "itvbackend_queue_depth 3")
+	require.Contains(t, body, "go_goroutines")
+}
+
+func TestMetrics_HostLabel(t *testing.T) {
+	m := metrics.NewMetrics()
+	for i := 0; i < 150; i++ {
+		m.ObserveFetch(fmt.Sprintf("host-%d.com", i), time.Millisecond)
+	}
+	m.ObserveFetch("host-0.com", time.Millisecond)
+
+	// Hosts beyond first ones share single series
+	body := scrape(m, t)
+	require.Contains(t, body, `itvbackend_fetch_duration_seconds_count{host="host-0.com"} 2`)
+	require.Contains(t, body, `itvbackend_fetch_duration_seconds_count{host="host-99.com"} 1`)
+	require.NotContains(t, body, `host="host-100.com"`)
+	require.Contains(t, body, `itvbackend_fetch_duration_seconds_count{host="OTHER"} 50`)
+}
+
+func TestMetrics_Nil(t *testing.T) {
+	var m *metrics.Metrics
+	require.NotPanics(t, func() {
+		m.Accepted(http.MethodGet)
+		m.Completed(http.MethodGet, http.StatusOK)
+		m.Failed(http.MethodGet, "timeout")
+		m.Gauge("workers", "Size of worker pool.", func() float64 { return 1 })
+	})
+
+	// Decorators work without metrics
+	f := metrics.NewFetcher(fetcher.NewMockFetcher(), m)
+	_, err := f.Fetch(context.Background(), "id", &model.FetchData{Method: http.MethodGet, URL: "http://google.com"})
+	require.Nil(t, err)
+	st := metrics.NewStorage(memory.NewMemoryStorage(), "memory", m)
+	_, err = st.AddRequest(&model.FetchData{Method: http.MethodGet, URL: "http://google.com"})
+	require.Nil(t, err)
+}
+
+type failingFetcher struct{}
+
+func (failingFetcher) Fetch(context.Context, string, *model.FetchData) (*model.Response, error) {
+	return nil, errors.New("rejected")
+}
+
+func TestMetrics_Decorators(t *testing.T) {
+	m := metrics.NewMetrics()
+	data := &model.FetchData{Method: http.MethodGet, URL: "http://google.com/search"}
+
+	_, err := metrics.NewFetcher(fetcher.NewMockFetcher(), m).Fetch(context.Background(), "id", data)
+	require.Nil(t, err)
+	_, err = metrics.NewFetcher(failingFetcher{}, m).Fetch(context.Background(), "id", &model.FetchData{URL: "http://yandex.ru"})
+	require.NotNil(t, err)
+
+	st := metrics.NewStorage(memory.NewMemoryStorage(), "memory", m)
+	id, err := st.AddRequest(data)
+	require.Nil(t, err)
+	_, err = st.GetRequest(id)
+	require.Nil(t, err)
+
+	body := scrape(m, t)
+	require.Contains(t, body, `itvbackend_fetch_duration_seconds_count{host="google.com"} 1`)
+	require.NotContains(t, body, `host="yandex.ru"`)
+	require.Contains(t, body, `itvbackend_storage_operation_duration_seconds_count{backend="memory",operation="add_request"} 1`)
+	require.Contains(t, body, `itvbackend_storage_operation_duration_seconds_count{backend="memory",operation="get_request"} 1`)
+}
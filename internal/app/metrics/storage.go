@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/ahamtat/itvbackend/internal/app/storage"
+)
+
+// Storage decorates storage recording latency of every operation.
+type Storage struct {
+	storage storage.Storage
+	backend string
+	metrics *Metrics
+}
+
+// NewStorage constructor, backend names storage in metric labels.
+func NewStorage(storage storage.Storage, backend string, metrics *Metrics) storage.Storage {
+	return &Storage{storage: storage, backend: backend, metrics: metrics}
+}
+
+// Bind returns storage bound to context of single call.
+func (s *Storage) Bind(ctx context.Context) storage.Storage {
+	return &Storage{storage: storage.Bind(ctx, s.storage), backend: s.backend, metrics: s.metrics}
+}
+
+// observe records latency of operation started at start time.
+func (s *Storage) observe(operation string, start time.Time) {
+	s.metrics.ObserveStorage(s.backend, operation, time.Since(start))
+}
+
+// AddRequest saves fetch data and return ID.
+func (s *Storage) AddRequest(data *model.FetchData) (string, error) {
+	defer s.observe("add_request", time.Now())
+	return s.storage.AddRequest(data)
+}
+
+// AddResponse saves response from external resource by request ID.
+func (s *Storage) AddResponse(id string, response *model.Response) error {
+	defer s.observe("add_response", time.Now())
+	return s.storage.AddResponse(id, response)
+}
+
+// UpdateState changes request processing state by ID.
+func (s *Storage) UpdateState(id string, state model.State) error {
+	defer s.observe("update_state", time.Now())
+	return s.storage.UpdateState(id, state)
+}
+
+// FailRequest marks request as failed and saves failure reason by ID.
+func (s *Storage) FailRequest(id string, reason string) error {
+	defer s.observe("fail_request", time.Now())
+	return s.storage.FailRequest(id, reason)
+}
+
+// GetRequest reads request from storage by ID.
+func (s *Storage) GetRequest(id string) (*model.Request, error) {
+	defer s.observe("get_request", time.Now())
+	return s.storage.GetRequest(id)
+}
+
+// FindRequests reads page of requests matching filter.
+func (s *Storage) FindRequests(filter *model.Filter, paginator *model.Paginator) (*model.Page, error) {
+	defer s.observe("find_requests", time.Now())
+	return s.storage.FindRequests(filter, paginator)
+}
+
+// DeleteRequest removes request from storage by ID.
+func (s *Storage) DeleteRequest(id string) error {
+	defer s.observe("delete_request", time.Now())
+	return s.storage.DeleteRequest(id)
+}
@@ -0,0 +1,27 @@
+package model
+
+import "time"
+
+// Filter of listed requests, zero fields match any request.
+type Filter struct {
+	// HTTP method of fetch data
+	Method string
+	// Host name of external resource URL, case insensitive
+	Host string
+	// Prefix of external resource URL
+	URLPrefix string
+	// Response status code
+	Status int
+	// Response status class, e.g. 2 for 2xx codes
+	StatusClass int
+	// Creation time range, lower bound is inclusive and upper one is exclusive
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+	// Kind of transport error
+	ErrorKind ErrorKind
+	// Case insensitive substring of external resource URL
+	Search string
+	// Name of fetch or response header and its value, empty value matches any one
+	Header      string
+	HeaderValue string
+}
@@ -1,8 +1,37 @@
 package model
 
+// Sort order of listed requests.
+type Sort string
+
+// Sort orders, requests created at the same time are ordered by creation sequence.
+const (
+	// Oldest requests first
+	SortCreatedAsc Sort = "createdAt"
+	// Newest requests first
+	SortCreatedDesc Sort = "-createdAt"
+)
+
 type Paginator struct {
-	// Current page number
+	// Current page number, it is ignored when cursor is set
 	Page int `json:"page"`
-	// Number of requests per page
+	// Number of requests per page, zero is unlimited
 	RequestsPerPage int `json:"requestsPerPage"`
+	// Opaque cursor from previous page
+	Cursor string `json:"cursor,omitempty"`
+	// Sort order, empty is SortCreatedAsc
+	Sort Sort `json:"sort,omitempty"`
+}
+
+// Descending reports whether newest requests go first.
+func (p *Paginator) Descending() bool {
+	return p != nil && p.Sort == SortCreatedDesc
+}
+
+// Page of listed requests.
+type Page struct {
+	Requests []Request `json:"requests"`
+	// Cursor of next page, empty on last page
+	NextCursor string `json:"nextCursor,omitempty"`
+	// Number of requests on all pages
+	Total int `json:"total"`
 }
@@ -1,23 +1,196 @@
 package model
 
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+// State of request processing.
+type State string
+
+// Request processing lifecycle.
+const (
+	StateQueued    State = "queued"
+	StateRunning   State = "running"
+	StateSucceeded State = "succeeded"
+	StateFailed    State = "failed"
+)
+
+// ErrorKind classifies failures of getting response from external resource.
+type ErrorKind string
+
+// Fetch error kinds.
+const (
+	ErrorKindDNS         ErrorKind = "dns"
+	ErrorKindConnect     ErrorKind = "connect"
+	ErrorKindTLS         ErrorKind = "tls"
+	ErrorKindTimeout     ErrorKind = "timeout"
+	ErrorKindCanceled    ErrorKind = "canceled"
+	ErrorKindBlocked     ErrorKind = "blocked"
+	ErrorKindCircuitOpen ErrorKind = "circuit_open"
+	ErrorKindOther       ErrorKind = "other"
+)
+
+// FetchError describes why no HTTP response was received from external resource.
+type FetchError struct {
+	Kind    ErrorKind `json:"kind"`
+	Message string    `json:"message"`
+}
+
+// Error implements error interface.
+func (e *FetchError) Error() string {
+	return string(e.Kind) + ": " + e.Message
+}
+
+// FetchOptions tune fetching of external resource per request.
+type FetchOptions struct {
+	// Capture response body from external resource
+	CaptureBody bool `json:"captureBody,omitempty"`
+	// Maximum size of captured body in bytes, zero means fetcher limit
+	MaxBodySize int64 `json:"maxBodySize,omitempty"`
+	// Request timeout in milliseconds, zero means fetcher timeout
+	TimeoutMS int64 `json:"timeoutMs,omitempty"`
+	// Follow redirects from external resource, nil means true
+	FollowRedirects *bool `json:"followRedirects,omitempty"`
+	// Maximum number of followed redirects, zero means fetcher limit
+	MaxRedirects int `json:"maxRedirects,omitempty"`
+	// Skip verification of external resource TLS certificate
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+	// Retry policy overriding fetcher defaults
+	Retry *RetryPolicy `json:"retry,omitempty"`
+}
+
+// RetryPolicy of fetching external resource.
+// Zero or nil fields are taken from default policy.
+type RetryPolicy struct {
+	// Maximum number of attempts including the first one
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// Initial backoff delay in milliseconds, doubled on every retry
+	BackoffBaseMS int64 `json:"backoffBaseMs,omitempty"`
+	// Maximum backoff delay in milliseconds
+	BackoffCapMS int64 `json:"backoffCapMs,omitempty"`
+	// Randomize backoff delay between zero and computed value, nil means true
+	Jitter *bool `json:"jitter,omitempty"`
+	// HTTP status codes to retry
+	RetryStatuses []int `json:"retryStatuses,omitempty"`
+	// Transport error kinds to retry
+	RetryErrors []ErrorKind `json:"retryErrors,omitempty"`
+	// Use delay from Retry-After header limited by backoff cap, nil means true
+	RespectRetryAfter *bool `json:"respectRetryAfter,omitempty"`
+	// Retry non-idempotent methods like POST and PATCH, they are not retried by default
+	RetryNonIdempotent bool `json:"retryNonIdempotent,omitempty"`
+}
+
+// Attempt of fetching external resource.
+type Attempt struct {
+	Status     int         `json:"status"`
+	Error      *FetchError `json:"error,omitempty"`
+	DurationMS int64       `json:"durationMs"`
+}
+
+// BodyEncoding of request body sent to external resource.
+type BodyEncoding string
+
+// Request body encodings.
+const (
+	// Body is sent as plain string
+	BodyEncodingText BodyEncoding = "text"
+	// Body is base64 encoded binary data
+	BodyEncodingBase64 BodyEncoding = "base64"
+	// Form values are sent URL encoded
+	BodyEncodingForm BodyEncoding = "form"
+	// Parts are sent as multipart form data
+	BodyEncodingMultipart BodyEncoding = "multipart"
+)
+
+// Part of multipart request body.
+type Part struct {
+	Name        string `json:"name"`
+	FileName    string `json:"fileName,omitempty"`
+	ContentType string `json:"contentType,omitempty"`
+	Body        string `json:"body,omitempty"`
+	// Part body is base64 encoded binary data
+	Base64 bool `json:"base64,omitempty"`
+}
+
 // FetchData from client (incoming) to external resource.
 type FetchData struct {
 	Method  string              `json:"method"`
 	URL     string              `json:"url"`
 	Headers map[string][]string `json:"headers,omitempty"`
 	Body    string              `json:"body,omitempty"`
+	// Encoding of request body, empty means plain text
+	BodyEncoding BodyEncoding        `json:"bodyEncoding,omitempty"`
+	Form         map[string][]string `json:"form,omitempty"`
+	Parts        []Part              `json:"parts,omitempty"`
+	FetchOptions
+}
+
+// HostName returns lower case host of external resource URL, it is empty for invalid URL.
+// Host name is the key of per-host limits, circuits, metrics and filters.
+func (d *FetchData) HostName() string {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// Redirect hop followed by fetcher.
+type Redirect struct {
+	// Redirecting URL
+	URL string `json:"url"`
+	// Redirect status code
+	Status int `json:"status"`
+	// Redirect target URL
+	Location string `json:"location"`
 }
 
 // Response data from external resource to client (outgoing).
+// Status is zero when no HTTP response was received, see Error.
 type Response struct {
 	ID      string              `json:"id"`
 	Status  int                 `json:"status"`
 	Headers map[string][]string `json:"headers,omitempty"`
 	Length  int64               `json:"length"`
+	// Captured response body
+	Body []byte `json:"body,omitempty"`
+	// Captured body was cut to maximum size
+	Truncated bool `json:"truncated,omitempty"`
+	// Transport error from external resource
+	Error *FetchError `json:"error,omitempty"`
+	// Redirect chain followed before final response
+	Redirects []Redirect `json:"redirects,omitempty"`
+	// Every attempt of fetching external resource
+	Attempts []Attempt `json:"attempts,omitempty"`
+	// Timing of last attempt
+	Timing *Timing `json:"timing,omitempty"`
+}
+
+// Timing breakdown of fetching external resource in milliseconds.
+// DNS, connect and TLS durations are summed over redirect chain.
+type Timing struct {
+	DNSMS     float64 `json:"dnsMs"`
+	ConnectMS float64 `json:"connectMs"`
+	TLSMS     float64 `json:"tlsMs"`
+	// Time from sending request to first byte of final response
+	FirstByteMS float64 `json:"firstByteMs"`
+	// Time of reading final response body
+	TransferMS float64 `json:"transferMs"`
+	TotalMS    float64 `json:"totalMs"`
+	// Remote address of final connection
+	RemoteAddr string `json:"remoteAddr,omitempty"`
+	// Final connection was reused from pool
+	Reused bool `json:"reused"`
 }
 
 // Request holds incoming and outgoing data.
 type Request struct {
-	Fetch    *FetchData `json:"fetch"`
-	Response *Response  `json:"response"`
+	ID        string     `json:"id"`
+	State     State      `json:"state"`
+	Error     string     `json:"error,omitempty"`
+	CreatedAt time.Time  `json:"createdAt"`
+	Fetch     *FetchData `json:"fetch"`
+	Response  *Response  `json:"response"`
 }
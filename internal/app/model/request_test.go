@@ -0,0 +1,14 @@
+package model_test
+
+import (
+	"testing"
+
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFetchData_HostName(t *testing.T) {
+	require.Equal(t, "example.com", (&model.FetchData{URL: "https://User@Example.com:8443/path"}).HostName())
+	require.Equal(t, "::1", (&model.FetchData{URL: "http://[::1]:8080/"}).HostName())
+	require.Equal(t, "", (&model.FetchData{URL: "://invalid"}).HostName())
+}
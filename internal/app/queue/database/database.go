@@ -0,0 +1,189 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	"github.com/sirupsen/logrus"
+
+	"github.com/ahamtat/itvbackend/internal/app/logging"
+	"github.com/ahamtat/itvbackend/internal/app/queue"
+)
+
+// Queue of jobs in PostgreSQL table claimed with SKIP LOCKED,
+// so several application instances could share it.
+type Queue struct {
+	ctx    context.Context
+	logger *logrus.Logger
+	db     *sqlx.DB
+	owner  string
+	lease  time.Duration
+}
+
+// NewDatabaseQueue constructor.
+// Owner identifies application instance holding leases of claimed jobs.
+// Nil logger is replaced with new one writing JSON entries.
+func NewDatabaseQueue(ctx context.Context, db *sql.DB, owner string, lease time.Duration, logger *logrus.Logger) queue.Queue {
+	if logger == nil {
+		logger = logging.NewLogger()
+	}
+	return &Queue{
+		ctx:    ctx,
+		logger: logger,
+		db:     sqlx.NewDb(db, "postgres"),
+		owner:  owner,
+		lease:  lease,
+	}
+}
+
+// Push adds job to queue, claims of pushed job are ignored.
+func (q *Queue) Push(job queue.Job) error {
+	// Create timed query context
+	ctx, cancel := context.WithTimeout(q.ctx, 5*time.Second)
+	defer cancel()
+
+	if _, err := q.db.ExecContext(ctx, "INSERT INTO jobs (request_uuid, request_id, trace_parent) VALUES ($1, $2, $3)",
+		job.ID, nullString(job.RequestID), nullString(job.TraceParent)); err != nil {
+		q.jobLog(job.ID).WithField("request_id", job.RequestID).Errorf("Push(): failed inserting into jobs table: %s", err)
+		return err
+	}
+	return nil
+}
+
+// Claim leases up to limit jobs ready for processing.
+// Jobs locked by other transactions are skipped instead of waiting for them.
+func (q *Queue) Claim(limit int) ([]queue.Job, error) {
+	// Create timed query context
+	ctx, cancel := context.WithTimeout(q.ctx, 5*time.Second)
+	defer cancel()
+
+	rows := make([]struct {
+		ID          string `db:"request_uuid"`
+		RequestID   string `db:"request_id"`
+		TraceParent string `db:"trace_parent"`
+		Claims      int    `db:"claims"`
+	}, 0)
+	err := q.db.SelectContext(
+		ctx,
+		&rows,
+		"UPDATE jobs SET locked_by=$1, locked_until=now() + $2 * interval '1 millisecond', claims=claims+1 "+
+			"WHERE id IN (SELECT id FROM jobs WHERE locked_until IS NULL OR locked_until < now() "+
+			"ORDER BY id LIMIT $3 FOR UPDATE SKIP LOCKED) "+
+			"RETURNING request_uuid, COALESCE(request_id, '') AS request_id, "+
+			"COALESCE(trace_parent, '') AS trace_parent, claims",
+		q.owner,
+		q.lease.Milliseconds(),
+		limit)
+	if err != nil {
+		q.logger.Errorf("Claim(): failed updating jobs table: %s", err)
+		return nil, err
+	}
+
+	result := make([]queue.Job, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, queue.Job{
+			ID:          row.ID,
+			RequestID:   row.RequestID,
+			TraceParent: row.TraceParent,
+			Claims:      row.Claims,
+		})
+	}
+	return result, nil
+}
+
+// Renew extends leases of jobs being processed.
+func (q *Queue) Renew(ids []string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	// Create timed query context
+	ctx, cancel := context.WithTimeout(q.ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := q.db.ExecContext(
+		ctx,
+		"UPDATE jobs SET locked_until=now() + $1 * interval '1 millisecond' "+
+			"WHERE locked_by=$2 AND request_uuid = ANY($3)",
+		q.lease.Milliseconds(),
+		q.owner,
+		pq.Array(ids))
+	if err != nil {
+		q.logger.Errorf("Renew(): failed updating jobs table: %s", err)
+	}
+	return err
+}
+
+// Complete removes processed job from queue.
+func (q *Queue) Complete(id string) error {
+	// Create timed query context
+	ctx, cancel := context.WithTimeout(q.ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := q.db.ExecContext(ctx, "DELETE FROM jobs WHERE request_uuid=$1 AND locked_by=$2", id, q.owner)
+	if err != nil {
+		q.jobLog(id).Errorf("Complete(): failed deleting from jobs table: %s", err)
+	}
+	return err
+}
+
+// Release returns unprocessed job to queue.
+// Claim of unprocessed job is not counted, so released jobs are not abandoned.
+func (q *Queue) Release(id string) error {
+	// Create timed query context
+	ctx, cancel := context.WithTimeout(q.ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := q.db.ExecContext(
+		ctx,
+		"UPDATE jobs SET locked_by=NULL, locked_until=NULL, claims=GREATEST(claims-1, 0) "+
+			"WHERE request_uuid=$1 AND locked_by=$2",
+		id,
+		q.owner)
+	if err != nil {
+		q.jobLog(id).Errorf("Release(): failed updating jobs table: %s", err)
+	}
+	return err
+}
+
+// Depth returns number of jobs in queue.
+func (q *Queue) Depth() (int, error) {
+	// Create timed query context
+	ctx, cancel := context.WithTimeout(q.ctx, 5*time.Second)
+	defer cancel()
+
+	var depth int
+	if err := q.db.GetContext(ctx, &depth, "SELECT count(*) FROM jobs"); err != nil {
+		q.logger.Errorf("Depth(): failed selecting from jobs table: %s", err)
+		return 0, err
+	}
+	return depth, nil
+}
+
+// Pending returns number of jobs waiting for claim, jobs with expired leases included.
+func (q *Queue) Pending() (int, error) {
+	// Create timed query context
+	ctx, cancel := context.WithTimeout(q.ctx, 5*time.Second)
+	defer cancel()
+
+	var pending int
+	if err := q.db.GetContext(ctx, &pending,
+		"SELECT count(*) FROM jobs WHERE locked_until IS NULL OR locked_until < now()"); err != nil {
+		q.logger.Errorf("Pending(): failed selecting from jobs table: %s", err)
+		return 0, err
+	}
+	return pending, nil
+}
+
+// jobLog returns log entry with ID of request queued as job.
+func (q *Queue) jobLog(id string) *logrus.Entry {
+	return q.logger.WithField("request_uuid", id)
+}
+
+// nullString stores empty string as NULL.
+func nullString(value string) sql.NullString {
+	return sql.NullString{String: value, Valid: len(value) > 0}
+}
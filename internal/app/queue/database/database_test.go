@@ -0,0 +1,141 @@
+package database_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/google/uuid"
+	_ "github.com/lib/pq" // initializing postgres driver
+	"github.com/stretchr/testify/require"
+
+	"github.com/ahamtat/itvbackend/internal/app/queue"
+	"github.com/ahamtat/itvbackend/internal/app/queue/database"
+)
+
+func TestDatabaseQueue_Jobs(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	// Create database queue
+	q := database.NewDatabaseQueue(context.Background(), db, "instance-1", 30*time.Second, nil)
+	first, second := uuid.New().String(), uuid.New().String()
+
+	// Push jobs with and without correlation ID
+	traceParent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	mock.ExpectExec("INSERT INTO jobs").
+		WithArgs(first, "correlation-1", traceParent).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	require.Nil(t, q.Push(queue.Job{ID: first, RequestID: "correlation-1", TraceParent: traceParent}))
+	mock.ExpectExec("INSERT INTO jobs").
+		WithArgs(second, nil, nil).
+		WillReturnResult(sqlmock.NewResult(2, 1))
+	require.Nil(t, q.Push(queue.Job{ID: second}))
+
+	// Claim jobs skipping locked ones
+	mock.ExpectQuery(`UPDATE jobs SET locked_by=\$1, (.+) FOR UPDATE SKIP LOCKED\) RETURNING request_uuid, (.+), claims`).
+		WithArgs("instance-1", int64(30000), 10).
+		WillReturnRows(sqlmock.NewRows([]string{"request_uuid", "request_id", "trace_parent", "claims"}).
+			AddRow(first, "correlation-1", traceParent, 1).
+			AddRow(second, "", "", 2))
+	jobs, err := q.Claim(10)
+	require.Nil(t, err)
+	require.Equal(t, []queue.Job{
+		{ID: first, RequestID: "correlation-1", TraceParent: traceParent, Claims: 1},
+		{ID: second, Claims: 2},
+	}, jobs)
+
+	// Renew leases of claimed jobs
+	mock.ExpectExec("UPDATE jobs SET locked_until").
+		WithArgs(int64(30000), "instance-1", "{\""+first+"\",\""+second+"\"}").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	require.Nil(t, q.Renew([]string{first, second}))
+	require.Nil(t, q.Renew(nil))
+
+	// Complete and release jobs
+	mock.ExpectExec("DELETE FROM jobs").
+		WithArgs(first, "instance-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.Nil(t, q.Complete(first))
+	mock.ExpectExec("UPDATE jobs SET locked_by=NULL").
+		WithArgs(second, "instance-1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.Nil(t, q.Release(second))
+
+	// Read queue depth
+	mock.ExpectQuery("SELECT count").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	depth, err := q.Depth()
+	require.Nil(t, err)
+	require.Equal(t, 1, depth)
+
+	// Read number of jobs waiting for claim
+	mock.ExpectQuery(`SELECT count\(\*\) FROM jobs WHERE locked_until IS NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	pending, err := q.Pending()
+	require.Nil(t, err)
+	require.Equal(t, 1, pending)
+
+	// Make sure that all expectations were met
+	require.Nil(t, mock.ExpectationsWereMet())
+}
+
+// TestDatabaseQueue_Postgres runs against migrated local database
+// if ITVBACKEND_TEST_DSN environment variable is set.
+func TestDatabaseQueue_Postgres(t *testing.T) {
+	dsn := os.Getenv("ITVBACKEND_TEST_DSN")
+	if len(dsn) == 0 {
+		t.Skip("ITVBACKEND_TEST_DSN is not set")
+	}
+	db, err := sql.Open("postgres", dsn)
+	require.Nil(t, err)
+	defer db.Close()
+
+	// Create requests for jobs
+	ids := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		id := uuid.New().String()
+		_, err = db.Exec("INSERT INTO requests (uuid, method, url) VALUES ($1, 'GET', 'http://google.com')", id)
+		require.Nil(t, err)
+		ids = append(ids, id)
+	}
+	defer func() {
+		for _, id := range ids {
+			_, _ = db.Exec("DELETE FROM requests WHERE uuid=$1", id)
+		}
+	}()
+
+	first := database.NewDatabaseQueue(context.Background(), db, "instance-1", 200*time.Millisecond, nil)
+	second := database.NewDatabaseQueue(context.Background(), db, "instance-2", time.Minute, nil)
+	for _, id := range ids {
+		require.Nil(t, first.Push(queue.Job{ID: id, RequestID: "correlation-" + id}))
+	}
+
+	// Instances never claim the same job
+	claimed := make(map[string]bool)
+	for _, q := range []queue.Queue{first, second} {
+		jobs, err := q.Claim(2)
+		require.Nil(t, err)
+		for _, job := range jobs {
+			require.False(t, claimed[job.ID])
+			require.Equal(t, "correlation-"+job.ID, job.RequestID)
+			claimed[job.ID] = true
+		}
+	}
+
+	// Jobs of crashed instance are claimed again after lease expires
+	time.Sleep(300 * time.Millisecond)
+	jobs, err := second.Claim(10)
+	require.Nil(t, err)
+	require.Equal(t, 2, len(jobs))
+	for _, job := range jobs {
+		require.Equal(t, 2, job.Claims)
+		require.Nil(t, second.Complete(job.ID))
+	}
+}
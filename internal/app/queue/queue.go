@@ -0,0 +1,38 @@
+package queue
+
+// Job claimed from queue.
+type Job struct {
+	// Request ID
+	ID string
+	// Correlation ID of API call pushing job, it could be empty
+	RequestID string
+	// W3C traceparent of API call pushing job, it could be empty
+	TraceParent string
+	// Number of times job was claimed, more than one means job is resumed
+	Claims int
+}
+
+// Queue of requests waiting for processing, it could be shared by application instances.
+// Claimed jobs are leased, so jobs of crashed instance are claimed again after lease expires.
+type Queue interface {
+	// Push adds job to queue, claims of pushed job are ignored.
+	Push(job Job) error
+
+	// Claim leases up to limit jobs ready for processing.
+	Claim(limit int) ([]Job, error)
+
+	// Renew extends leases of jobs being processed.
+	Renew(IDs []string) error
+
+	// Complete removes processed job from queue.
+	Complete(ID string) error
+
+	// Release returns unprocessed job to queue without counting its claim.
+	Release(ID string) error
+
+	// Depth returns number of jobs in queue.
+	Depth() (int, error)
+
+	// Pending returns number of jobs waiting for claim, jobs with expired leases included.
+	Pending() (int, error)
+}
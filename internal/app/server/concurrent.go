@@ -1,14 +1,23 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
 	"net/http"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/limiter"
+	"github.com/ahamtat/itvbackend/internal/app/logging"
+	"github.com/ahamtat/itvbackend/internal/app/metrics"
 	"github.com/ahamtat/itvbackend/internal/app/storage"
+	"github.com/ahamtat/itvbackend/internal/app/tracing"
 	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"github.com/ahamtat/itvbackend/internal/app/model"
 )
@@ -16,105 +25,530 @@ import (
 // ConcurrentServer data
 type ConcurrentServer struct {
 	router  *mux.Router
+	handler http.Handler
 	logger  *logrus.Logger
 	fetcher fetcher.Fetcher
+	breaker *fetcher.BreakerFetcher
 	storage storage.Storage
+	metrics *metrics.Metrics
+	health  *Health
 
 	poolSize int
-	taskCh   chan *model.FetchData
+	busy     int64
+	alive    int64
+	queue    QueueSettings
+	taskCh   chan *task
 	wg       sync.WaitGroup
+	// Room of queue shared by tasks in channel and ones waiting for hosts
+	room    chan struct{}
+	waiting int64
+
+	// Tasks waiting for saturated hosts are handed back to workers when admitted
+	limiter *limiter.HostLimiter
+	readyCh chan *task
+	tasks   sync.WaitGroup
+	quit    chan struct{}
+
+	// Shutdown stops accepting tasks and aborts waiting ones on deadline
+	closing   chan struct{}
+	closed    bool
+	closedMx  sync.RWMutex
+	abort     chan struct{}
+	abortCtx  context.Context
+	abortFunc context.CancelFunc
+	requeued  int64
+
+	// Jobs claimed from durable queue are fed to task channel
+	held     map[string]bool
+	heldMx   sync.Mutex
+	pushedCh chan struct{}
+	stopFeed chan struct{}
+	feedWG   sync.WaitGroup
+}
+
+// task for worker goroutine.
+type task struct {
+	id   string
+	data *model.FetchData
+	// Correlation ID of API call creating task
+	requestID string
+	// W3C traceparent of API call creating task
+	traceParent string
+	release     func()
+	// Task is claimed from durable queue
+	job bool
+	// Task holds room of queue until it is processed
+	room bool
+	// Time of sending task to task channel
+	queued time.Time
 }
 
 // NewConcurrentServer constructor.
-func NewConcurrentServer(poolSize int, fetcher fetcher.Fetcher, storage storage.Storage) http.Handler {
+// Tasks are fed from durable queue if it is set in queue settings.
+// Worker pool and task queue checks are added to health of options.
+func NewConcurrentServer(poolSize int, settings QueueSettings, fetcher fetcher.Fetcher, storage storage.Storage,
+	options Options) http.Handler {
+	if settings.Size <= 0 {
+		settings.Size = poolSize
+	}
+	if settings.Poll <= 0 {
+		settings.Poll = DefaultQueueSettings.Poll
+	}
+	if settings.Renew <= 0 {
+		settings.Renew = DefaultQueueSettings.Renew
+	}
+	if settings.MaxJobs <= 0 {
+		settings.MaxJobs = settings.Size
+	}
+	logger := logging.NewLogger()
+	health := options.Health
+	if health == nil {
+		health = NewHealth(logger)
+	}
 	s := &ConcurrentServer{
 		router:   mux.NewRouter(),
-		fetcher:  fetcher,
+		fetcher:  tracing.NewFetcher(fetcher),
+		breaker:  options.Breaker,
 		storage:  storage,
-		logger:   logrus.New(),
+		metrics:  options.Metrics,
+		health:   health,
+		logger:   logger,
 		poolSize: poolSize,
-		taskCh:   make(chan *model.FetchData, poolSize),
+		queue:    settings,
+		taskCh:   make(chan *task, settings.Size),
+		room:     make(chan struct{}, settings.Size),
+		limiter:  options.Limiter,
+		readyCh:  make(chan *task),
+		quit:     make(chan struct{}),
+		closing:  make(chan struct{}),
+		abort:    make(chan struct{}),
+		held:     make(map[string]bool),
+		pushedCh: make(chan struct{}, 1),
+		stopFeed: make(chan struct{}),
 	}
+	s.abortCtx, s.abortFunc = context.WithCancel(context.Background())
 	s.configureRouter()
+	s.configureRouterV2()
+	s.handler = handleTrace(s.router, handleAccess(s.logger, s.router))
+
+	health.AddLiveness("workers", s.checkWorkers)
+	health.AddReadiness("queue", s.checkQueue)
+
+	s.metrics.Gauge("queue_depth", "Tasks waiting in task channel.", func() float64 {
+		return float64(len(s.taskCh))
+	})
+	s.metrics.Gauge("queue_waiting", "Tasks waiting for saturated hosts.", func() float64 {
+		return float64(atomic.LoadInt64(&s.waiting))
+	})
+	s.metrics.Gauge("workers_busy", "Workers processing tasks.", func() float64 {
+		return float64(atomic.LoadInt64(&s.busy))
+	})
+	s.metrics.Gauge("workers", "Size of worker pool.", func() float64 {
+		return float64(poolSize)
+	})
 
 	// Create workers
 	s.wg.Add(poolSize)
+	s.alive = int64(poolSize)
 	for i := 0; i < poolSize; i++ {
 		go s.worker()
 	}
+
+	// Feed workers from durable queue renewing leases of claimed jobs
+	if settings.Jobs != nil {
+		s.feedWG.Add(1)
+		go s.feeder()
+		s.wg.Add(1)
+		go s.renewer()
+	}
 	return s
 }
 
 func (s *ConcurrentServer) worker() {
 	// Mark this goroutine as done! once the function exits
 	defer s.wg.Done()
+	defer atomic.AddInt64(&s.alive, -1)
 
 	// Make tasks blocking reading
-	for data := range s.taskCh {
-		// Save request to storage
-		id, err := s.storage.AddRequest(data)
-		if err != nil {
-			s.logger.Errorf("worker(): error saving request to storage: %s", err)
+	taskCh := s.taskCh
+	for {
+		// Aborted worker leaves queued tasks to shutdown
+		select {
+		case <-s.abort:
 			return
+		default:
 		}
 
-		// Fetch response from external resource
-		resp, err := s.fetcher.Fetch(id, data)
+		select {
+		case t := <-s.readyCh:
+			s.process(t)
+		case t, ok := <-taskCh:
+			if !ok {
+				// Keep serving admitted tasks until all of them are done
+				taskCh = nil
+				continue
+			}
+			s.schedule(t)
+		case <-s.abort:
+			return
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// schedule processes task at once if its host is not saturated,
+// otherwise task waits for the host without occupying worker.
+// Waiting task keeps its room of queue, so number of waiting tasks is limited by queue size.
+func (s *ConcurrentServer) schedule(t *task) {
+	host := t.data.HostName()
+	if release, ok := s.limiter.TryAcquire(host); ok {
+		t.release = release
+		s.process(t)
+		return
+	}
+
+	atomic.AddInt64(&s.waiting, 1)
+	go func() {
+		release, waited, err := s.limiter.Acquire(s.abortCtx, host)
+		atomic.AddInt64(&s.waiting, -1)
 		if err != nil {
-			s.logger.Errorf("worker(): error fetching response from external resource: %s", err)
+			if s.abortCtx.Err() != nil {
+				s.requeue(t)
+				return
+			}
+			s.taskLog(t).Errorf("schedule(): error waiting for host %s: %s", host, err)
+			s.leave(t)
+			s.failRequest(t.context(), t, err)
+			s.done(t)
 			return
 		}
+		s.taskLog(t).Infof("task waited %s for host %s", waited, host)
+		t.release = release
+		select {
+		case s.readyCh <- t:
+		case <-s.abort:
+			s.requeue(t)
+		}
+	}()
+}
 
-		// Save response to storage
-		if err := s.storage.AddResponse(id, resp); err != nil {
-			s.logger.Errorf("worker(): error saving response to storage: %s", err)
+func (s *ConcurrentServer) process(t *task) {
+	s.leave(t)
+
+	// Task interrupted by shutdown is returned to queue
+	aborted := false
+	defer func() {
+		if aborted {
+			if t.job {
+				s.updateState(t.context(), t, model.StateQueued)
+			}
+			s.requeue(t)
 			return
 		}
+		t.release()
+		s.done(t)
+	}()
+
+	// Processing continues trace of API call creating task, fetching is interrupted on shutdown deadline
+	ctx, span := tracing.Tracer().Start(t.bind(s.abortCtx), "task.process",
+		trace.WithAttributes(attribute.String("request.uuid", t.id), attribute.String("request.id", t.requestID)))
+	defer span.End()
+
+	// Keep worker alive on unexpected task panic
+	defer func() {
+		if r := recover(); r != nil {
+			s.taskLog(t).Errorf("process(): recovered from panic: %v", r)
+			s.failRequest(ctx, t, errors.Errorf("panic: %v", r))
+		}
+	}()
+
+	atomic.AddInt64(&s.busy, 1)
+	defer atomic.AddInt64(&s.busy, -1)
+	if !t.queued.IsZero() {
+		s.metrics.ObserveQueueWait(time.Since(t.queued))
+		_, wait := tracing.Tracer().Start(t.context(), "queue.wait", trace.WithTimestamp(t.queued))
+		wait.End()
+	}
+
+	s.updateState(ctx, t, model.StateRunning)
 
-		s.logger.Infoln("task processed") // Should be Debugln in production ;)
+	// Fetch response from external resource
+	resp, err := s.fetcher.Fetch(ctx, t.id, t.data)
+	if err == nil && resp.Error != nil && resp.Error.Kind == model.ErrorKindCanceled && s.abortCtx.Err() != nil {
+		s.taskLog(t).Infoln("process(): fetching is interrupted by shutdown")
+		aborted = true
+		return
+	}
+	observeResult(s.metrics, t.data, resp, err)
+	if err != nil {
+		s.taskLog(t).Errorf("process(): error fetching response from external resource: %s", err)
+		s.failRequest(ctx, t, err)
+		return
 	}
+
+	// Save response to storage
+	if err := tracing.NewStorage(ctx, s.storage).AddResponse(t.id, resp); err != nil {
+		s.taskLog(t).Errorf("process(): error saving response to storage: %s", err)
+		s.failRequest(ctx, t, err)
+		return
+	}
+
+	// Request without HTTP response from external resource is failed
+	if resp.Error != nil {
+		s.failRequest(ctx, t, resp.Error)
+	} else {
+		s.updateState(ctx, t, model.StateSucceeded)
+	}
+
+	s.taskLog(t).Infoln("task processed") // Should be Debugln in production ;)
+}
+
+func (s *ConcurrentServer) updateState(ctx context.Context, t *task, state model.State) {
+	if err := tracing.NewStorage(ctx, s.storage).UpdateState(t.id, state); err != nil {
+		s.taskLog(t).Errorf("updateState(): error saving request state %s to storage: %s", state, err)
+	}
+}
+
+func (s *ConcurrentServer) failRequest(ctx context.Context, t *task, reason error) {
+	if err := tracing.NewStorage(ctx, s.storage).FailRequest(t.id, reason.Error()); err != nil {
+		s.taskLog(t).Errorf("failRequest(): error saving request failure to storage: %s", err)
+	}
+}
+
+// leave frees room of queue held by task.
+func (s *ConcurrentServer) leave(t *task) {
+	if t.room {
+		t.room = false
+		<-s.room
+	}
+}
+
+// context returns context continuing trace of API call creating task.
+func (t *task) context() context.Context {
+	return t.bind(context.Background())
+}
+
+// bind returns context carrying trace and correlation ID of API call creating task.
+func (t *task) bind(ctx context.Context) context.Context {
+	ctx = logging.WithCall(tracing.WithTraceParent(ctx, t.traceParent), t.requestID)
+	logging.SetRequestUUID(ctx, t.id)
+	return ctx
+}
+
+// taskLog returns log entry with correlation ID and request ID of task.
+func (s *ConcurrentServer) taskLog(t *task) *logrus.Entry {
+	return logging.Entry(s.logger, t.context())
+}
+
+// log returns log entry with correlation ID of API call.
+func (s *ConcurrentServer) log(ctx context.Context) *logrus.Entry {
+	return logging.Entry(s.logger, ctx)
+}
+
+// store returns storage recording operations in trace of API call.
+func (s *ConcurrentServer) store(ctx context.Context) storage.Storage {
+	return tracing.NewStorage(ctx, s.storage)
 }
 
 // ServeHTTP implementation for external handler.
 func (s *ConcurrentServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.router.ServeHTTP(w, r)
+	s.handler.ServeHTTP(w, r)
 }
 
 func (s *ConcurrentServer) configureRouter() {
 	requests := s.router.PathPrefix("/v1/requests").Subrouter()
-	requests.HandleFunc("/request", s.handleRequest()).Methods("POST")
+	requests.HandleFunc("/request", handleRequest(s, s.makeRequest)).Methods("POST", "DELETE")
+	requests.HandleFunc("/list", handleListAllRequests(s)).Methods("GET")
+	requests.HandleFunc("/{id}", handleGetRequest(s)).Methods("GET")
+	requests.HandleFunc("/{id}/body", handleGetResponseBody(s)).Methods("GET")
+
+	admin := s.router.PathPrefix("/v1/admin").Subrouter()
+	admin.HandleFunc("/hosts", s.handleListHosts()).Methods("GET")
+	admin.HandleFunc("/breakers", handleListBreakers(s.breaker)).Methods("GET")
+	admin.HandleFunc("/queue", s.handleGetQueue()).Methods("GET")
+
+	s.router.HandleFunc("/healthz", handleLiveness(s.health)).Methods("GET")
+	s.router.HandleFunc("/readyz", handleReadiness(s.health)).Methods("GET")
+
+	if s.metrics != nil {
+		s.router.Handle("/metrics", s.metrics.Handler()).Methods("GET")
+	}
+}
+
+// configureRouterV2 adds RESTful API with request IDs in path.
+func (s *ConcurrentServer) configureRouterV2() {
+	requests := s.router.PathPrefix("/v2/requests").Subrouter()
+	requests.HandleFunc("", s.handleCreateRequestV2()).Methods("POST")
+	requests.HandleFunc("", handleListRequestsV2(s)).Methods("GET")
+	requests.HandleFunc("/{id}", handleGetRequestV2(s)).Methods("GET")
+	requests.HandleFunc("/{id}", handleDeleteRequestV2(s)).Methods("DELETE")
 }
 
-func (s *ConcurrentServer) handleRequest() http.HandlerFunc {
+// handleCreateRequestV2 queues request and returns it for status polling.
+func (s *ConcurrentServer) handleCreateRequestV2() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			s.makeRequest(w, r)
-		default:
-			sendError(w, http.StatusBadRequest, nil)
+		data, err := decodeFetchData(r)
+		if err != nil {
+			s.log(r.Context()).Errorf("handleCreateRequestV2(): invalid fetch data: %s", err)
+			sendError(w, err)
+			return
+		}
+
+		accepted, err := s.accept(r.Context(), data)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrQueueFull):
+				w.Header().Set("Retry-After", s.retryAfter())
+				sendError(w, err)
+			case r.Context().Err() == nil:
+				sendError(w, err)
+			}
+			// Disconnected client gets no response
+			return
 		}
+
+		// Return queued request for status polling
+		w.Header().Set("Location", requestLocationV2(accepted.ID))
+		respond(w, http.StatusAccepted, accepted)
 	}
 }
 
 func (s *ConcurrentServer) makeRequest(w http.ResponseWriter, r *http.Request) {
-	if r.Body == nil {
-		s.logger.Errorln("makeRequest(): invalid request body")
-		sendError(w, http.StatusBadRequest, nil)
+	data, err := decodeFetchData(r)
+	if err != nil {
+		s.log(r.Context()).Errorf("makeRequest(): invalid fetch data: %s", err)
+		sendError(w, err)
 		return
 	}
-	data := &model.FetchData{}
-	if err := json.NewDecoder(r.Body).Decode(data); err != nil {
-		s.logger.Errorf("makeRequest(): error decoding request body: %s", err)
-		sendError(w, http.StatusInternalServerError, err)
+
+	accepted, err := s.accept(r.Context(), data)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrQueueFull):
+			w.Header().Set("Retry-After", s.retryAfter())
+			sendError(w, err)
+		case r.Context().Err() == nil:
+			sendError(w, err)
+		}
+		// Disconnected client gets no response
 		return
 	}
 
-	// Send data to task channel
-	s.taskCh <- data
+	// Return request ID for status polling
+	w.Header().Set("Location", "/v1/requests/"+accepted.ID)
+	respond(w, http.StatusAccepted, accepted)
+}
+
+// accept saves queued request to storage and sends it to task queue.
+// Request rejected by queue is deleted from storage.
+func (s *ConcurrentServer) accept(ctx context.Context, data *model.FetchData) (*model.Request, error) {
+	// Save queued request to storage
+	ID, err := s.store(ctx).AddRequest(data)
+	if err != nil {
+		s.log(ctx).Errorf("accept(): error saving request to storage: %s", err)
+		return nil, err
+	}
+	logging.SetRequestUUID(ctx, ID)
+	log := s.log(ctx)
+
+	// Stored request carries its creation time
+	accepted, err := s.store(ctx).GetRequest(ID)
+	if err != nil {
+		log.Errorf("accept(): error reading request from storage: %s", err)
+		accepted = &model.Request{ID: ID, State: model.StateQueued, Fetch: data}
+	}
+
+	// Send data to task channel unless it is full
+	t := &task{
+		id:          ID,
+		data:        data,
+		requestID:   logging.RequestID(ctx),
+		traceParent: tracing.TraceParent(ctx),
+		queued:      time.Now(),
+	}
+	if err := s.enqueue(ctx, t); err != nil {
+		log.Errorf("accept(): error sending task to queue: %s", err)
+		if deleteErr := s.store(ctx).DeleteRequest(ID); deleteErr != nil {
+			log.Errorf("accept(): error deleting rejected request from storage: %s", deleteErr)
+		}
+		return nil, err
+	}
+	s.metrics.Accepted(data.Method)
+	return accepted, nil
 }
 
-// Close task channel to inform worker goroutines.
+// Close waits until all queued and waiting tasks are processed.
 func (s *ConcurrentServer) Close() {
+	_ = s.Shutdown(context.Background())
+}
+
+// Shutdown stops accepting tasks and drains task queue until context is done.
+// Tasks left unprocessed on deadline, including ones interrupted while fetching,
+// are returned to durable queue staying queued to be resumed on next start,
+// tasks kept in memory only are failed. Jobs left in durable queue are processed after restart.
+func (s *ConcurrentServer) Shutdown(ctx context.Context) error {
+	close(s.stopFeed)
+	s.feedWG.Wait()
+
+	// Handlers waiting for room or sending tasks after this point get ErrShuttingDown
+	close(s.closing)
+	s.closedMx.Lock()
+	s.closed = true
 	close(s.taskCh)
+	s.closedMx.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		s.tasks.Wait()
+		close(drained)
+	}()
+
+	var err error
+	select {
+	case <-drained:
+	case <-ctx.Done():
+		err = ctx.Err()
+
+		// Stop workers and waiting tasks, then return queued tasks
+		close(s.abort)
+		s.abortFunc()
+		for t := range s.taskCh {
+			s.requeue(t)
+		}
+		<-drained
+		s.logger.Infof("Shutdown(): %d unprocessed tasks are returned to queue", atomic.LoadInt64(&s.requeued))
+	}
+	s.abortFunc()
+	close(s.quit)
 	s.wg.Wait()
+	return err
+}
+
+// requeue returns task left unprocessed on shutdown.
+// Job is released to durable queue, task without job could not be resumed.
+func (s *ConcurrentServer) requeue(t *task) {
+	s.leave(t)
+	if t.release != nil {
+		t.release()
+	}
+	atomic.AddInt64(&s.requeued, 1)
+	if t.job {
+		s.release(t)
+		return
+	}
+	s.failRequest(t.context(), t, ErrShuttingDown)
+	s.tasks.Done()
+}
+
+func (s *ConcurrentServer) handleListHosts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, s.limiter.Stats())
+	}
+}
+
+func (s *ConcurrentServer) handleGetQueue() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, s.queueStats())
+	}
 }
@@ -1,20 +1,837 @@
 package server_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ahamtat/itvbackend/internal/app/model"
 	"github.com/ahamtat/itvbackend/internal/app/storage/memory"
 
 	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/limiter"
+	"github.com/ahamtat/itvbackend/internal/app/metrics"
+	"github.com/ahamtat/itvbackend/internal/app/queue"
 	"github.com/ahamtat/itvbackend/internal/app/server"
+	"github.com/ahamtat/itvbackend/internal/app/tracing"
 )
 
 func TestConcurrentServer_FetchResponse(t *testing.T) {
 	s := server.NewConcurrentServer(
 		5,
+		server.DefaultQueueSettings,
+		fetcher.NewMockFetcher(),
+		memory.NewMemoryStorage(),
+		server.Options{})
+
+	populateStorage(s, http.StatusAccepted, t)
+	s.(*server.ConcurrentServer).Close()
+}
+
+// waitForRequests polls request states until all of them are finished.
+func waitForRequests(s http.Handler, generatedID []string, t *testing.T) {
+	require.Eventually(t, func() bool {
+		for _, ID := range generatedID {
+			state := getRequest(s, ID, http.StatusOK, t).State
+			if state != model.StateSucceeded && state != model.StateFailed {
+				return false
+			}
+		}
+		return true
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConcurrentServer_GetResponse(t *testing.T) {
+	s := server.NewConcurrentServer(
+		5,
+		server.DefaultQueueSettings,
+		fetcher.NewMockFetcher(),
+		memory.NewMemoryStorage(),
+		server.Options{})
+	defer s.(*server.ConcurrentServer).Close()
+
+	generatedID := populateStorage(s, http.StatusAccepted, t)
+	waitForRequests(s, generatedID, t)
+
+	for _, ID := range generatedID {
+		req := getRequest(s, ID, http.StatusOK, t)
+		require.Equal(t, model.StateSucceeded, req.State)
+		require.NotNil(t, req.Response)
+		require.Equal(t, ID, req.Response.ID)
+	}
+}
+
+func TestConcurrentServer_ListAndDeleteResponse(t *testing.T) {
+	s := server.NewConcurrentServer(
+		5,
+		server.DefaultQueueSettings,
+		fetcher.NewMockFetcher(),
+		memory.NewMemoryStorage(),
+		server.Options{})
+	defer s.(*server.ConcurrentServer).Close()
+
+	waitForRequests(s, populateStorage(s, http.StatusAccepted, t), t)
+	requests := readAndDecodeRequests(s, len(fetchData), nil, t)
+
+	// Delete responses from storage
+	for _, req := range requests {
+		deleteRequest(s, req.ID, http.StatusOK, t)
+	}
+
+	// Check if storage is empty
+	emptyRequest := readAndDecodeRequests(s, 0, nil, t)
+	require.Empty(t, emptyRequest)
+}
+
+func TestConcurrentServer_InvalidFetchData(t *testing.T) {
+	s := server.NewConcurrentServer(
+		1,
+		server.DefaultQueueSettings,
 		fetcher.NewMockFetcher(),
-		memory.NewMemoryStorage())
+		memory.NewMemoryStorage(),
+		server.Options{})
+	defer s.(*server.ConcurrentServer).Close()
+
+	// Invalid fetch data is rejected before queueing
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/v1/requests/request", bytes.NewBufferString(`{"url":"google.com"}`))
+	require.Nil(t, err)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	require.Empty(t, rec.Header().Get("Location"))
+
+	result := server.ErrorResponse{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.Equal(t, server.CodeValidationFailed, result.Code)
+	require.Equal(t, []server.FieldError{
+		{Field: "method", Message: "method is required"},
+		{Field: "url", Message: "absolute URL is required"},
+	}, result.Details)
+	require.Empty(t, readAndDecodeRequests(s, 0, nil, t))
+}
+
+// faultyFetcher fails on fetching special URLs.
+type faultyFetcher struct{}
+
+func (f *faultyFetcher) Fetch(ctx context.Context, id string, data *model.FetchData) (*model.Response, error) {
+	switch data.URL {
+	case "http://panic.com":
+		panic("unexpected fetcher error")
+	case "http://nonexistent.invalid":
+		return &model.Response{
+			ID: id,
+			Error: &model.FetchError{
+				Kind:    model.ErrorKindDNS,
+				Message: "no such host",
+			},
+		}, nil
+	}
+	return fetcher.NewMockFetcher().Fetch(ctx, id, data)
+}
+
+func TestConcurrentServer_WorkerFailures(t *testing.T) {
+	// Single worker must survive all failed tasks
+	s := server.NewConcurrentServer(
+		1,
+		server.DefaultQueueSettings,
+		&faultyFetcher{},
+		memory.NewMemoryStorage(),
+		server.Options{})
+	defer s.(*server.ConcurrentServer).Close()
+
+	testCases := []struct {
+		name   string
+		fetch  *model.FetchData
+		state  model.State
+		reason string
+	}{
+		{
+			name:   "Validation error",
+			fetch:  &model.FetchData{Method: "FETCH", URL: "http://google.com"},
+			state:  model.StateFailed,
+			reason: fetcher.ErrWrongHTTPMethod.Error(),
+		},
+		{
+			name:   "Fetcher panic",
+			fetch:  &model.FetchData{Method: "GET", URL: "http://panic.com"},
+			state:  model.StateFailed,
+			reason: "panic: unexpected fetcher error",
+		},
+		{
+			name:   "Transport error",
+			fetch:  &model.FetchData{Method: "GET", URL: "http://nonexistent.invalid"},
+			state:  model.StateFailed,
+			reason: "dns: no such host",
+		},
+		{
+			name:   "Valid request",
+			fetch:  &model.FetchData{Method: "GET", URL: "http://google.com"},
+			state:  model.StateSucceeded,
+			reason: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ID := postRequest(s, tc.fetch, http.StatusAccepted, t)
+			waitForRequests(s, []string{ID}, t)
+
+			req := getRequest(s, ID, http.StatusOK, t)
+			require.Equal(t, tc.state, req.State)
+			require.Equal(t, tc.reason, req.Error)
+		})
+	}
+}
+
+// slowFetcher counts concurrent requests per host.
+type slowFetcher struct {
+	mx       sync.Mutex
+	inFlight map[string]int
+	max      map[string]int
+}
+
+func (f *slowFetcher) Fetch(ctx context.Context, id string, data *model.FetchData) (*model.Response, error) {
+	host := data.HostName()
+	f.mx.Lock()
+	f.inFlight[host]++
+	if f.inFlight[host] > f.max[host] {
+		f.max[host] = f.inFlight[host]
+	}
+	f.mx.Unlock()
+
+	if host == "slow.com" {
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	f.mx.Lock()
+	f.inFlight[host]--
+	f.mx.Unlock()
+	return fetcher.NewMockFetcher().Fetch(ctx, id, data)
+}
+
+func TestConcurrentServer_HostLimits(t *testing.T) {
+	f := &slowFetcher{inFlight: make(map[string]int), max: make(map[string]int)}
+	l := limiter.NewHostLimiter(limiter.Limits{}, map[string]limiter.Limits{
+		"slow.com": {MaxInFlight: 1},
+	})
+	s := server.NewConcurrentServer(2, server.DefaultQueueSettings, f, memory.NewMemoryStorage(), server.Options{Limiter: l})
+	defer s.(*server.ConcurrentServer).Close()
+
+	slowID := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		slowID = append(slowID, postRequest(s, &model.FetchData{Method: "GET", URL: "http://slow.com"}, http.StatusAccepted, t))
+	}
+
+	// Saturated host does not occupy workers
+	fastID := postRequest(s, &model.FetchData{Method: "GET", URL: "http://fast.com"}, http.StatusAccepted, t)
+	waitForRequests(s, []string{fastID}, t)
+	require.NotEqual(t, model.StateSucceeded, getRequest(s, slowID[len(slowID)-1], http.StatusOK, t).State)
+
+	waitForRequests(s, slowID, t)
+	require.Equal(t, 1, f.max["slow.com"])
+
+	// Host statistics
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/v1/admin/hosts", nil)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	stats := make(map[string]limiter.Stats)
+	require.Nil(t, json.NewDecoder(rec.Body).Decode(&stats))
+	require.Equal(t, int64(4), stats["slow.com"].Admitted)
+	require.Equal(t, 1, stats["slow.com"].MaxInFlight)
+	require.True(t, stats["slow.com"].MaxQueueTimeMS >= 50)
+	require.Equal(t, int64(1), stats["fast.com"].Admitted)
+	require.Equal(t, 0, stats["fast.com"].Waiting)
+}
+
+// blockingFetcher holds requests until it is released.
+type blockingFetcher struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (f *blockingFetcher) Fetch(ctx context.Context, id string, data *model.FetchData) (*model.Response, error) {
+	f.started <- struct{}{}
+	<-f.release
+	return fetcher.NewMockFetcher().Fetch(ctx, id, data)
+}
+
+func TestConcurrentServer_QueueBackpressure(t *testing.T) {
+	f := &blockingFetcher{started: make(chan struct{}, 10), release: make(chan struct{})}
+	s := server.NewConcurrentServer(
+		1,
+		server.QueueSettings{Size: 1, RetryAfter: 1500 * time.Millisecond},
+		f,
+		memory.NewMemoryStorage(),
+		server.Options{})
+	defer s.(*server.ConcurrentServer).Close()
+	data := &model.FetchData{Method: "GET", URL: "http://google.com"}
+	release := sync.Once{}
+	defer release.Do(func() { close(f.release) })
+
+	// Worker is busy with first task, second one is queued
+	postRequest(s, data, http.StatusAccepted, t)
+	<-f.started
+	postRequest(s, data, http.StatusAccepted, t)
+
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/v1/admin/queue", nil)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	stats := server.QueueStats{}
+	require.Nil(t, json.NewDecoder(rec.Body).Decode(&stats))
+	require.Equal(t, server.QueueStats{Depth: 1, Capacity: 1}, stats)
+
+	// Full queue rejects task at once
+	rec = postWithContext(s, context.Background(), data)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "2", rec.Header().Get("Retry-After"))
+
+	// Rejected request is not stored
+	readAndDecodeRequests(s, 2, nil, t)
+}
+
+func TestConcurrentServer_QueueWaitingHosts(t *testing.T) {
+	f := &blockingFetcher{started: make(chan struct{}, 10), release: make(chan struct{})}
+	l := limiter.NewHostLimiter(limiter.Limits{MaxInFlight: 1}, nil)
+	s := server.NewConcurrentServer(2, server.QueueSettings{Size: 2}, f, memory.NewMemoryStorage(), server.Options{Limiter: l})
+	defer s.(*server.ConcurrentServer).Close()
+	data := &model.FetchData{Method: "GET", URL: "http://slow.com"}
+	release := sync.Once{}
+	defer release.Do(func() { close(f.release) })
+
+	// Worker is busy with first task, others wait for saturated host
+	generatedID := []string{postRequest(s, data, http.StatusAccepted, t)}
+	<-f.started
+	generatedID = append(generatedID, postRequest(s, data, http.StatusAccepted, t))
+	generatedID = append(generatedID, postRequest(s, data, http.StatusAccepted, t))
+
+	stats := server.QueueStats{}
+	require.Eventually(t, func() bool {
+		rec := httptest.NewRecorder()
+		req, _ := http.NewRequest(http.MethodGet, "/v1/admin/queue", nil)
+		s.ServeHTTP(rec, req)
+		require.Nil(t, json.NewDecoder(rec.Body).Decode(&stats))
+		return stats.Waiting == 2
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, server.QueueStats{Depth: 2, Capacity: 2, Waiting: 2}, stats)
+
+	// Tasks waiting for hosts are counted as queued
+	rec := postWithContext(s, context.Background(), &model.FetchData{Method: "GET", URL: "http://fast.com"})
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	release.Do(func() { close(f.release) })
+	waitForRequests(s, generatedID, t)
+	postRequest(s, data, http.StatusAccepted, t)
+}
+
+func TestConcurrentServer_QueueWait(t *testing.T) {
+	f := &blockingFetcher{started: make(chan struct{}, 10), release: make(chan struct{})}
+	s := server.NewConcurrentServer(
+		1,
+		server.QueueSettings{Size: 1, Wait: time.Second},
+		f,
+		memory.NewMemoryStorage(),
+		server.Options{})
+	defer s.(*server.ConcurrentServer).Close()
+	data := &model.FetchData{Method: "GET", URL: "http://google.com"}
+	release := sync.Once{}
+	defer release.Do(func() { close(f.release) })
+
+	postRequest(s, data, http.StatusAccepted, t)
+	<-f.started
+	postRequest(s, data, http.StatusAccepted, t)
+
+	// Disconnected client stops waiting and gets no response
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	rec := postWithContext(s, ctx, data)
+	require.Empty(t, rec.Body.String())
+	readAndDecodeRequests(s, 2, nil, t)
+
+	// Task waits for room in full queue
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		release.Do(func() { close(f.release) })
+	}()
+	postRequest(s, data, http.StatusAccepted, t)
+}
+
+func postWithContext(s http.Handler, ctx context.Context, data *model.FetchData) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(data)
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodPost, "/v1/requests/request", bytes.NewReader(body))
+	s.ServeHTTP(rec, req)
+	return rec
+}
+
+// jobQueue keeps durable queue jobs in memory.
+type jobQueue struct {
+	mx       sync.Mutex
+	jobs     []string
+	pushed   map[string]queue.Job
+	claims   map[string]int
+	claimed  map[string]bool
+	complete []string
+}
 
-	populateStorage(s, t)
+func newJobQueue() *jobQueue {
+	return &jobQueue{
+		pushed:  make(map[string]queue.Job),
+		claims:  make(map[string]int),
+		claimed: make(map[string]bool),
+	}
+}
+
+func (q *jobQueue) Push(job queue.Job) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.jobs = append(q.jobs, job.ID)
+	q.pushed[job.ID] = job
+	return nil
+}
+
+func (q *jobQueue) Claim(limit int) ([]queue.Job, error) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	result := make([]queue.Job, 0)
+	for _, id := range q.jobs {
+		if len(result) < limit && !q.claimed[id] {
+			q.claimed[id] = true
+			q.claims[id]++
+			job := q.pushed[id]
+			job.Claims = q.claims[id]
+			result = append(result, job)
+		}
+	}
+	return result, nil
+}
+
+func (q *jobQueue) Renew(ids []string) error { return nil }
+
+func (q *jobQueue) Complete(id string) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	for i := range q.jobs {
+		if q.jobs[i] == id {
+			q.jobs = append(q.jobs[:i], q.jobs[i+1:]...)
+			break
+		}
+	}
+	q.complete = append(q.complete, id)
+	return nil
+}
+
+func (q *jobQueue) Release(id string) error {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	q.claimed[id] = false
+	q.claims[id]--
+	return nil
+}
+
+func (q *jobQueue) Depth() (int, error) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	return len(q.jobs), nil
+}
+
+func (q *jobQueue) Pending() (int, error) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+	pending := 0
+	for _, id := range q.jobs {
+		if !q.claimed[id] {
+			pending++
+		}
+	}
+	return pending, nil
+}
+
+func TestConcurrentServer_DurableQueue(t *testing.T) {
+	st := memory.NewMemoryStorage()
+	jobs := newJobQueue()
+
+	// Jobs left by previous instance
+	resumed, err := st.AddRequest(&model.FetchData{Method: "GET", URL: "http://google.com"})
+	require.Nil(t, err)
+	abandoned, err := st.AddRequest(&model.FetchData{Method: "GET", URL: "http://google.com"})
+	require.Nil(t, err)
+	require.Nil(t, jobs.Push(queue.Job{ID: resumed}))
+	require.Nil(t, jobs.Push(queue.Job{ID: abandoned}))
+	require.Nil(t, jobs.Push(queue.Job{ID: uuid.New().String()})) // deleted request
+	jobs.claims[abandoned] = 3
+
+	settings := server.DefaultQueueSettings
+	settings.Jobs = jobs
+	settings.Poll = 10 * time.Millisecond
+	settings.MaxClaims = 3
+	s := server.NewConcurrentServer(2, settings, fetcher.NewMockFetcher(), st, server.Options{})
+
+	// New jobs are pushed to durable queue
+	generatedID := populateStorage(s, http.StatusAccepted, t)
+	waitForRequests(s, append(generatedID, resumed, abandoned), t)
 	s.(*server.ConcurrentServer).Close()
+
+	require.Equal(t, model.StateSucceeded, getRequest(s, resumed, http.StatusOK, t).State)
+	req := getRequest(s, abandoned, http.StatusOK, t)
+	require.Equal(t, model.StateFailed, req.State)
+	require.Equal(t, "job is abandoned after 3 claims", req.Error)
+
+	depth, err := jobs.Depth()
+	require.Nil(t, err)
+	require.Zero(t, depth)
+	require.Equal(t, len(generatedID)+3, len(jobs.complete))
+}
+
+func TestConcurrentServer_DurableQueueFull(t *testing.T) {
+	st := memory.NewMemoryStorage()
+	jobs := newJobQueue()
+
+	// Jobs pushed by other instances wait for claim
+	for i := 0; i < 2; i++ {
+		require.Nil(t, jobs.Push(queue.Job{ID: uuid.New().String()}))
+	}
+
+	settings := server.DefaultQueueSettings
+	settings.Jobs = jobs
+	settings.Poll = time.Hour
+	settings.Wait = 100 * time.Millisecond
+	settings.RetryAfter = 3 * time.Second
+	settings.MaxJobs = 2
+	s := server.NewConcurrentServer(1, settings, fetcher.NewMockFetcher(), st, server.Options{})
+	defer s.(*server.ConcurrentServer).Close()
+	data := &model.FetchData{Method: "GET", URL: "http://google.com"}
+
+	// Feeder claims jobs on start, so wait for jobs pushed after it
+	require.Eventually(t, func() bool {
+		pending, _ := jobs.Pending()
+		return pending == 0
+	}, time.Second, 10*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		require.Nil(t, jobs.Push(queue.Job{ID: uuid.New().String()}))
+	}
+
+	// Full durable queue rejects task after waiting and reports not ready
+	status := getHealth(s, "/readyz", http.StatusServiceUnavailable, t)
+	require.Equal(t, server.HealthFail, status.Components["queue"].Status)
+	rec := postWithContext(s, context.Background(), data)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	require.Equal(t, "3", rec.Header().Get("Retry-After"))
+	depth, err := jobs.Depth()
+	require.Nil(t, err)
+	require.Equal(t, 2, depth)
+
+	// Task is accepted when room appears while waiting
+	time.AfterFunc(20*time.Millisecond, func() {
+		jobs.mx.Lock()
+		defer jobs.mx.Unlock()
+		jobs.claimed[jobs.jobs[0]] = true
+	})
+	postRequest(s, data, http.StatusAccepted, t)
+}
+
+func TestConcurrentServer_Metrics(t *testing.T) {
+	f := &blockingFetcher{started: make(chan struct{}, 10), release: make(chan struct{})}
+	s := server.NewConcurrentServer(
+		1,
+		server.QueueSettings{Size: 1},
+		f,
+		memory.NewMemoryStorage(),
+		server.Options{Metrics: metrics.NewMetrics()})
+	defer s.(*server.ConcurrentServer).Close()
+	data := &model.FetchData{Method: "GET", URL: "http://google.com"}
+
+	// Worker is busy with first task and second one waits in queue
+	generatedID := []string{postRequest(s, data, http.StatusAccepted, t)}
+	<-f.started
+	generatedID = append(generatedID, postRequest(s, data, http.StatusAccepted, t))
+
+	body := scrapeMetrics(s, t)
+	require.Contains(t, body, `itvbackend_requests_accepted_total{method="GET"} 2`)
+	require.Contains(t, body, "itvbackend_queue_depth 1")
+	require.Contains(t, body, "itvbackend_workers_busy 1")
+	require.Contains(t, body, "itvbackend_workers 1")
+
+	close(f.release)
+	waitForRequests(s, generatedID, t)
+
+	body = scrapeMetrics(s, t)
+	require.Contains(t, body, `itvbackend_requests_completed_total{method="GET",status_class="2xx"} 2`)
+	require.Contains(t, body, "itvbackend_queue_depth 0")
+	require.Contains(t, body, "itvbackend_queue_wait_seconds_count 2")
+}
+
+func TestConcurrentServer_Shutdown(t *testing.T) {
+	f := &blockingFetcher{started: make(chan struct{}, 10), release: make(chan struct{})}
+	s := server.NewConcurrentServer(
+		1,
+		server.QueueSettings{Size: 2},
+		f,
+		memory.NewMemoryStorage(),
+		server.Options{})
+	data := &model.FetchData{Method: "GET", URL: "http://google.com"}
+
+	// Worker is busy with first task and others wait in queue
+	generatedID := []string{postRequest(s, data, http.StatusAccepted, t)}
+	<-f.started
+	generatedID = append(generatedID, postRequest(s, data, http.StatusAccepted, t))
+	generatedID = append(generatedID, postRequest(s, data, http.StatusAccepted, t))
+
+	// Task being processed is finished after deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	time.AfterFunc(100*time.Millisecond, func() { close(f.release) })
+	require.Equal(t, context.DeadlineExceeded, s.(*server.ConcurrentServer).Shutdown(ctx))
+
+	require.Equal(t, model.StateSucceeded, getRequest(s, generatedID[0], http.StatusOK, t).State)
+	for _, ID := range generatedID[1:] {
+		req := getRequest(s, ID, http.StatusOK, t)
+		require.Equal(t, model.StateFailed, req.State)
+		require.Equal(t, server.ErrShuttingDown.Error(), req.Error)
+	}
+
+	// Requests are rejected after shutdown
+	body, err := json.Marshal(data)
+	require.Nil(t, err)
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/v1/requests/request", bytes.NewReader(body))
+	require.Nil(t, err)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	result := &server.ErrorResponse{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), result))
+	require.Equal(t, server.CodeShuttingDown, result.Code)
+}
+
+func TestConcurrentServer_ShutdownWaitingProducer(t *testing.T) {
+	f := &blockingFetcher{started: make(chan struct{}, 10), release: make(chan struct{})}
+	s := server.NewConcurrentServer(
+		1,
+		server.QueueSettings{Size: 1, Wait: time.Minute},
+		f,
+		memory.NewMemoryStorage(),
+		server.Options{})
+	data := &model.FetchData{Method: "GET", URL: "http://google.com"}
+
+	// Worker is busy, queue is full and producer waits for room
+	postRequest(s, data, http.StatusAccepted, t)
+	<-f.started
+	postRequest(s, data, http.StatusAccepted, t)
+	waiting := make(chan *httptest.ResponseRecorder)
+	go func() {
+		waiting <- postWithContext(s, context.Background(), data)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	// Waiting producer does not delay shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	time.AfterFunc(100*time.Millisecond, func() { close(f.release) })
+	start := time.Now()
+	require.Equal(t, context.DeadlineExceeded, s.(*server.ConcurrentServer).Shutdown(ctx))
+	require.True(t, time.Since(start) < time.Second)
+
+	rec := <-waiting
+	require.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	result := &server.ErrorResponse{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), result))
+	require.Equal(t, server.CodeShuttingDown, result.Code)
+}
+
+func TestConcurrentServer_ShutdownDurableQueue(t *testing.T) {
+	f := &blockingFetcher{started: make(chan struct{}, 10), release: make(chan struct{})}
+	st := memory.NewMemoryStorage()
+	jobs := newJobQueue()
+	settings := server.DefaultQueueSettings
+	settings.Size = 1
+	settings.Jobs = jobs
+	settings.Poll = 10 * time.Millisecond
+	s := server.NewConcurrentServer(1, settings, f, st, server.Options{})
+	data := &model.FetchData{Method: "GET", URL: "http://google.com"}
+
+	generatedID := []string{postRequest(s, data, http.StatusAccepted, t)}
+	<-f.started
+	generatedID = append(generatedID, postRequest(s, data, http.StatusAccepted, t))
+
+	// Wait until second job is claimed to task channel
+	require.Eventually(t, func() bool {
+		jobs.mx.Lock()
+		defer jobs.mx.Unlock()
+		return jobs.claimed[generatedID[1]]
+	}, time.Second, 10*time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	time.AfterFunc(100*time.Millisecond, func() { close(f.release) })
+	require.Equal(t, context.DeadlineExceeded, s.(*server.ConcurrentServer).Shutdown(ctx))
+
+	// Unprocessed job stays queued to be resumed on next start
+	require.Equal(t, model.StateSucceeded, getRequest(s, generatedID[0], http.StatusOK, t).State)
+	require.Equal(t, model.StateQueued, getRequest(s, generatedID[1], http.StatusOK, t).State)
+	jobs.mx.Lock()
+	defer jobs.mx.Unlock()
+	require.Equal(t, []string{generatedID[1]}, jobs.jobs)
+	require.False(t, jobs.claimed[generatedID[1]])
+	require.Equal(t, 0, jobs.claims[generatedID[1]])
+}
+
+// cancelableFetcher holds requests until their context is done.
+type cancelableFetcher struct {
+	started chan struct{}
+}
+
+func (f *cancelableFetcher) Fetch(ctx context.Context, id string, data *model.FetchData) (*model.Response, error) {
+	f.started <- struct{}{}
+	<-ctx.Done()
+	return &model.Response{ID: id, Error: &model.FetchError{Kind: model.ErrorKindCanceled, Message: ctx.Err().Error()}}, nil
+}
+
+func TestConcurrentServer_ShutdownInterruptsFetch(t *testing.T) {
+	f := &cancelableFetcher{started: make(chan struct{}, 10)}
+	st := memory.NewMemoryStorage()
+	jobs := newJobQueue()
+	settings := server.DefaultQueueSettings
+	settings.Jobs = jobs
+	settings.Poll = 10 * time.Millisecond
+	s := server.NewConcurrentServer(1, settings, f, st, server.Options{})
+	data := &model.FetchData{Method: "GET", URL: "http://google.com"}
+
+	ID := postRequest(s, data, http.StatusAccepted, t)
+	<-f.started
+
+	// Fetch hanging past deadline is interrupted and its job is returned to queue
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	done := make(chan error)
+	go func() { done <- s.(*server.ConcurrentServer).Shutdown(ctx) }()
+	select {
+	case err := <-done:
+		require.Equal(t, context.DeadlineExceeded, err)
+	case <-time.After(time.Second):
+		t.Fatal("shutdown is blocked by fetch")
+	}
+
+	require.Equal(t, model.StateQueued, getRequest(s, ID, http.StatusOK, t).State)
+	jobs.mx.Lock()
+	defer jobs.mx.Unlock()
+	require.Equal(t, []string{ID}, jobs.jobs)
+	require.False(t, jobs.claimed[ID])
+}
+
+func TestConcurrentServer_RequestID(t *testing.T) {
+	jobs := newJobQueue()
+	settings := server.DefaultQueueSettings
+	settings.Jobs = jobs
+	settings.Poll = 10 * time.Millisecond
+	s := server.NewConcurrentServer(2, settings, fetcher.NewMockFetcher(), memory.NewMemoryStorage(), server.Options{})
+	defer s.(*server.ConcurrentServer).Close()
+
+	// Correlation ID of API call is kept with job for worker
+	body, err := json.Marshal(&fetchData[0])
+	require.Nil(t, err)
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/v2/requests", bytes.NewReader(body))
+	require.Nil(t, err)
+	req.Header.Set(server.RequestIDHeader, "correlation-1")
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+	require.Equal(t, "correlation-1", rec.Header().Get(server.RequestIDHeader))
+
+	created := &model.Request{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), created))
+	waitForRequests(s, []string{created.ID}, t)
+
+	jobs.mx.Lock()
+	defer jobs.mx.Unlock()
+	require.Equal(t, "correlation-1", jobs.pushed[created.ID].RequestID)
+	require.Equal(t, []string{created.ID}, jobs.complete)
+}
+
+func TestConcurrentServer_Tracing(t *testing.T) {
+	_, err := tracing.Setup(context.Background(), tracing.DefaultSettings)
+	require.Nil(t, err)
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	jobs := newJobQueue()
+	settings := server.DefaultQueueSettings
+	settings.Jobs = jobs
+	settings.Poll = 10 * time.Millisecond
+	s := server.NewConcurrentServer(2, settings, fetcher.NewMockFetcher(), memory.NewMemoryStorage(), server.Options{})
+	defer s.(*server.ConcurrentServer).Close()
+
+	// Trace of client is continued by API call and by worker claiming job
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	body, err := json.Marshal(&fetchData[0])
+	require.Nil(t, err)
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/v2/requests", bytes.NewReader(body))
+	require.Nil(t, err)
+	req.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusAccepted, rec.Code)
+
+	created := &model.Request{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), created))
+	waitForRequests(s, []string{created.ID}, t)
+
+	jobs.mx.Lock()
+	require.Contains(t, jobs.pushed[created.ID].TraceParent, traceID)
+	jobs.mx.Unlock()
+
+	// Polling calls without traceparent start own traces
+	names := func() map[string]bool {
+		result := make(map[string]bool)
+		for _, span := range sr.Ended() {
+			if span.SpanContext().TraceID().String() == traceID {
+				result[span.Name()] = true
+			}
+		}
+		return result
+	}
+	require.Eventually(t, func() bool { return names()["task.process"] }, time.Second, 10*time.Millisecond)
+
+	spans := names()
+	for _, name := range []string{
+		"HTTP POST /v2/requests",
+		"storage.add_request",
+		"queue.wait",
+		"storage.update_state",
+		"HTTP " + fetchData[0].Method,
+		"storage.add_response",
+	} {
+		require.True(t, spans[name], name)
+	}
+
+	// Storage operations of v2 handlers are recorded in trace of API call
+	const pollTraceID = "0af7651916cd43dd8448eb211c80319c"
+	rec = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/v2/requests/"+created.ID, nil)
+	require.Nil(t, err)
+	req.Header.Set("traceparent", "00-"+pollTraceID+"-b7ad6b7169203331-01")
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	polled := false
+	for _, span := range sr.Ended() {
+		if span.SpanContext().TraceID().String() == pollTraceID && span.Name() == "storage.get_request" {
+			polled = true
+		}
+	}
+	require.True(t, polled)
 }
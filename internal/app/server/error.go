@@ -0,0 +1,173 @@
+package server
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/storage"
+	"github.com/pkg/errors"
+)
+
+// ErrorCode is stable machine-readable code of error response.
+type ErrorCode string
+
+// Error codes.
+const (
+	CodeMalformedBody      ErrorCode = "malformed_body"
+	CodeInvalidParameter   ErrorCode = "invalid_parameter"
+	CodeInvalidInput       ErrorCode = "invalid_input"
+	CodeInvalidCursor      ErrorCode = "invalid_cursor"
+	CodeInvalidSort        ErrorCode = "invalid_sort"
+	CodeValidationFailed   ErrorCode = "validation_failed"
+	CodeInvalidFetchData   ErrorCode = "invalid_fetch_data"
+	CodeMethodNotAllowed   ErrorCode = "method_not_allowed"
+	CodeInvalidFetchBody   ErrorCode = "invalid_fetch_body"
+	CodeInsecureNotAllowed ErrorCode = "insecure_not_allowed"
+	CodeRequestNotFound    ErrorCode = "request_not_found"
+	CodeBodyNotCaptured    ErrorCode = "body_not_captured"
+	CodeQueueFull          ErrorCode = "queue_full"
+	CodeShuttingDown       ErrorCode = "shutting_down"
+	CodeInternal           ErrorCode = "internal_error"
+)
+
+// ErrEmptyBody is returned for request without body.
+var ErrEmptyBody = errors.New("request body is empty")
+
+// FieldError describes invalid field of request.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ErrorResponse is sent to client on failure.
+type ErrorResponse struct {
+	// HTTP status code
+	Status int `json:"status"`
+	// Machine-readable error code
+	Code ErrorCode `json:"code"`
+	// Human-readable error message
+	Message string `json:"message"`
+	// Invalid fields of request
+	Details []FieldError `json:"details,omitempty"`
+}
+
+// ValidationError lists invalid fields of fetch data.
+type ValidationError struct {
+	Fields []FieldError
+}
+
+// Error implements error interface.
+func (e *ValidationError) Error() string {
+	messages := make([]string, 0, len(e.Fields))
+	for _, field := range e.Fields {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+	return "invalid fetch data: " + strings.Join(messages, ", ")
+}
+
+// ParameterError is invalid query parameter of request.
+type ParameterError struct {
+	Name string
+	Err  error
+}
+
+// Error implements error interface.
+func (e *ParameterError) Error() string {
+	return "invalid parameter " + e.Name + ": " + e.Err.Error()
+}
+
+// sentinel maps known error to HTTP status code and machine-readable code.
+type sentinel struct {
+	err    error
+	status int
+	code   ErrorCode
+}
+
+// sentinels are matched with errors.Is, so wrapped errors are mapped as well.
+var sentinels = []sentinel{
+	{ErrEmptyBody, http.StatusBadRequest, CodeMalformedBody},
+	{io.EOF, http.StatusBadRequest, CodeMalformedBody},
+	{io.ErrUnexpectedEOF, http.StatusBadRequest, CodeMalformedBody},
+	{storage.ErrInvalidInputData, http.StatusBadRequest, CodeInvalidInput},
+	{storage.ErrInvalidCursor, http.StatusBadRequest, CodeInvalidCursor},
+	{storage.ErrInvalidSort, http.StatusBadRequest, CodeInvalidSort},
+	{fetcher.ErrInvalidInputData, http.StatusUnprocessableEntity, CodeInvalidFetchData},
+	{fetcher.ErrWrongHTTPMethod, http.StatusUnprocessableEntity, CodeMethodNotAllowed},
+	{fetcher.ErrInvalidBody, http.StatusUnprocessableEntity, CodeInvalidFetchBody},
+	{fetcher.ErrInsecureNotAllowed, http.StatusUnprocessableEntity, CodeInsecureNotAllowed},
+	{storage.ErrRequestNotFound, http.StatusNotFound, CodeRequestNotFound},
+	{ErrBodyNotCaptured, http.StatusNotFound, CodeBodyNotCaptured},
+	{ErrQueueFull, http.StatusServiceUnavailable, CodeQueueFull},
+	{ErrShuttingDown, http.StatusServiceUnavailable, CodeShuttingDown},
+}
+
+// errorStatus maps error to HTTP status code, unknown errors are internal ones.
+func errorStatus(err error) int {
+	var validation *ValidationError
+	var parameter *ParameterError
+	var syntax *json.SyntaxError
+	var unmarshal *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &validation):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &parameter), errors.As(err, &syntax), errors.As(err, &unmarshal):
+		return http.StatusBadRequest
+	}
+	for _, known := range sentinels {
+		if errors.Is(err, known.err) {
+			return known.status
+		}
+	}
+	return http.StatusInternalServerError
+}
+
+// errorCode maps error to machine-readable code, unknown errors are internal ones.
+func errorCode(err error) ErrorCode {
+	var validation *ValidationError
+	var parameter *ParameterError
+	var syntax *json.SyntaxError
+	var unmarshal *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &validation):
+		return CodeValidationFailed
+	case errors.As(err, &parameter):
+		return CodeInvalidParameter
+	case errors.As(err, &syntax), errors.As(err, &unmarshal):
+		return CodeMalformedBody
+	}
+	for _, known := range sentinels {
+		if errors.Is(err, known.err) {
+			return known.code
+		}
+	}
+	return CodeInternal
+}
+
+// sendError writes error response with HTTP status mapped from error.
+// Nil and unknown errors are reported as internal ones with generic message.
+func sendError(w http.ResponseWriter, err error) {
+	resp := &ErrorResponse{
+		Status:  http.StatusInternalServerError,
+		Code:    CodeInternal,
+		Message: http.StatusText(http.StatusInternalServerError),
+	}
+	// Internal errors could reveal storage details, so they are only logged
+	if err != nil && errorStatus(err) != http.StatusInternalServerError {
+		resp.Status = errorStatus(err)
+		resp.Code = errorCode(err)
+		resp.Message = err.Error()
+	}
+	var validation *ValidationError
+	var parameter *ParameterError
+	switch {
+	case errors.As(err, &validation):
+		resp.Details = validation.Fields
+	case errors.As(err, &parameter):
+		resp.Details = []FieldError{{Field: parameter.Name, Message: parameter.Err.Error()}}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	respond(w, resp.Status, resp)
+}
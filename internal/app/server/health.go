@@ -0,0 +1,151 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ahamtat/itvbackend/internal/app/logging"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrDraining is reported by readiness check during graceful shutdown.
+var ErrDraining = errors.New("application is shutting down")
+
+// Health statuses.
+const (
+	HealthOK   = "ok"
+	HealthFail = "fail"
+)
+
+// DefaultCheckTimeout limits time of single health check.
+const DefaultCheckTimeout = 2 * time.Second
+
+// Check of application component, nil error means component is healthy.
+type Check func(ctx context.Context) error
+
+// ComponentStatus is result of component check.
+// Check errors could reveal dependency details, so they are only logged.
+type ComponentStatus struct {
+	Status string `json:"status"`
+}
+
+// HealthStatus is sent by health endpoints.
+type HealthStatus struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components,omitempty"`
+}
+
+// namedCheck keeps check with component name.
+type namedCheck struct {
+	name  string
+	check Check
+}
+
+// Health runs liveness and readiness checks of application components.
+// Readiness includes liveness checks, so dead component takes instance out of traffic.
+type Health struct {
+	mx        sync.Mutex
+	liveness  []namedCheck
+	readiness []namedCheck
+	draining  int32
+	timeout   time.Duration
+	logger    *logrus.Logger
+}
+
+// NewHealth constructor, nil logger is replaced with JSON one.
+func NewHealth(logger *logrus.Logger) *Health {
+	if logger == nil {
+		logger = logging.NewLogger()
+	}
+	return &Health{timeout: DefaultCheckTimeout, logger: logger}
+}
+
+// AddLiveness adds check failing when application must be restarted.
+func (h *Health) AddLiveness(name string, check Check) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.liveness = append(h.liveness, namedCheck{name: name, check: check})
+}
+
+// AddReadiness adds check failing when application could not take traffic.
+func (h *Health) AddReadiness(name string, check Check) {
+	h.mx.Lock()
+	defer h.mx.Unlock()
+	h.readiness = append(h.readiness, namedCheck{name: name, check: check})
+}
+
+// Drain flips readiness to failing for the rest of application life.
+func (h *Health) Drain() {
+	atomic.StoreInt32(&h.draining, 1)
+}
+
+// Live runs liveness checks.
+func (h *Health) Live(ctx context.Context) *HealthStatus {
+	h.mx.Lock()
+	checks := append([]namedCheck(nil), h.liveness...)
+	h.mx.Unlock()
+	return h.run(ctx, checks)
+}
+
+// Ready runs liveness and readiness checks.
+func (h *Health) Ready(ctx context.Context) *HealthStatus {
+	h.mx.Lock()
+	checks := append(append([]namedCheck(nil), h.liveness...), h.readiness...)
+	h.mx.Unlock()
+	if atomic.LoadInt32(&h.draining) == 1 {
+		checks = append(checks, namedCheck{name: "shutdown", check: func(context.Context) error {
+			return ErrDraining
+		}})
+	}
+	return h.run(ctx, checks)
+}
+
+// run executes checks sequentially, each one is limited by check timeout.
+func (h *Health) run(ctx context.Context, checks []namedCheck) *HealthStatus {
+	status := &HealthStatus{Status: HealthOK}
+	if len(checks) == 0 {
+		return status
+	}
+	status.Components = make(map[string]ComponentStatus, len(checks))
+	for _, c := range checks {
+		checkCtx, cancel := context.WithTimeout(ctx, h.timeout)
+		err := c.check(checkCtx)
+		cancel()
+
+		if err != nil {
+			logging.Entry(h.logger, ctx).WithField("component", c.name).Warnf("run(): check failed: %s", err)
+			status.Status = HealthFail
+			status.Components[c.name] = ComponentStatus{Status: HealthFail}
+			continue
+		}
+		status.Components[c.name] = ComponentStatus{Status: HealthOK}
+	}
+	return status
+}
+
+// sendHealth writes health status, failed one is sent with 503 status code.
+func sendHealth(w http.ResponseWriter, status *HealthStatus) {
+	code := http.StatusOK
+	if status.Status != HealthOK {
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	respond(w, code, status)
+}
+
+func handleLiveness(h *Health) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendHealth(w, h.Live(r.Context()))
+	}
+}
+
+func handleReadiness(h *Health) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sendHealth(w, h.Ready(r.Context()))
+	}
+}
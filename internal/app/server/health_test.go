@@ -0,0 +1,106 @@
+package server_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/ahamtat/itvbackend/internal/app/server"
+	"github.com/ahamtat/itvbackend/internal/app/storage/memory"
+)
+
+// getHealth reads health status from endpoint and checks status code.
+func getHealth(s http.Handler, target string, expected int, t *testing.T) *server.HealthStatus {
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, target, nil)
+	require.Nil(t, err)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, expected, rec.Code, rec.Body.String())
+
+	status := &server.HealthStatus{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), status))
+	return status
+}
+
+func TestServer_Health(t *testing.T) {
+	logger, hook := logtest.NewNullLogger()
+	health := server.NewHealth(logger)
+	failing := errors.New("dial tcp db.internal:5432: connection refused")
+	var dbErr error
+	health.AddReadiness("database", func(context.Context) error { return dbErr })
+
+	s := server.NewServer(
+		fetcher.NewMockFetcher(),
+		memory.NewMemoryStorage(),
+		server.Options{Health: health})
+
+	require.Equal(t, server.HealthOK, getHealth(s, "/healthz", http.StatusOK, t).Status)
+	status := getHealth(s, "/readyz", http.StatusOK, t)
+	require.Equal(t, server.ComponentStatus{Status: server.HealthOK}, status.Components["database"])
+
+	// Failed dependency takes instance out of traffic but keeps it alive
+	dbErr = failing
+	getHealth(s, "/healthz", http.StatusOK, t)
+	status = getHealth(s, "/readyz", http.StatusServiceUnavailable, t)
+	require.Equal(t, server.HealthFail, status.Status)
+	require.Equal(t, server.ComponentStatus{Status: server.HealthFail}, status.Components["database"])
+
+	// Check error is logged but not sent to client
+	require.Equal(t, "database", hook.LastEntry().Data["component"])
+	require.Contains(t, hook.LastEntry().Message, failing.Error())
+
+	// Readiness fails during shutdown
+	dbErr = nil
+	health.Drain()
+	status = getHealth(s, "/readyz", http.StatusServiceUnavailable, t)
+	require.Equal(t, server.HealthOK, status.Components["database"].Status)
+	require.Equal(t, server.HealthFail, status.Components["shutdown"].Status)
+	getHealth(s, "/healthz", http.StatusOK, t)
+}
+
+func TestConcurrentServer_Health(t *testing.T) {
+	f := &blockingFetcher{started: make(chan struct{}, 10), release: make(chan struct{})}
+	s := server.NewConcurrentServer(
+		1,
+		server.QueueSettings{Size: 1},
+		f,
+		memory.NewMemoryStorage(),
+		server.Options{})
+	data := &model.FetchData{Method: "GET", URL: "http://google.com"}
+
+	status := getHealth(s, "/readyz", http.StatusOK, t)
+	require.Equal(t, server.HealthOK, status.Components["workers"].Status)
+	require.Equal(t, server.HealthOK, status.Components["queue"].Status)
+
+	// Saturated queue takes instance out of traffic
+	postRequest(s, data, http.StatusAccepted, t)
+	<-f.started
+	postRequest(s, data, http.StatusAccepted, t)
+	status = getHealth(s, "/readyz", http.StatusServiceUnavailable, t)
+	require.Equal(t, server.HealthFail, status.Components["queue"].Status)
+	getHealth(s, "/healthz", http.StatusOK, t)
+
+	// Instance is ready again when queue is drained
+	close(f.release)
+	require.Eventually(t, func() bool {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/readyz", nil)
+		require.Nil(t, err)
+		s.ServeHTTP(rec, req)
+		return rec.Code == http.StatusOK
+	}, time.Second, 10*time.Millisecond)
+
+	// Stopped worker pool fails liveness
+	s.(*server.ConcurrentServer).Close()
+	status = getHealth(s, "/healthz", http.StatusServiceUnavailable, t)
+	require.Equal(t, server.HealthFail, status.Components["workers"].Status)
+}
@@ -2,12 +2,20 @@ package server
 
 import (
 	"encoding/json"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahamtat/itvbackend/internal/app/metrics"
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/pkg/errors"
 )
 
-func sendError(w http.ResponseWriter, code int, err error) {
-	respond(w, code, map[string]string{"error": err.Error()})
-}
+// ErrBodyNotCaptured is returned for response without captured body.
+var ErrBodyNotCaptured = errors.New("response body is not captured")
 
 func respond(w http.ResponseWriter, code int, data interface{}) {
 	w.WriteHeader(code)
@@ -17,3 +25,115 @@ func respond(w http.ResponseWriter, code int, data interface{}) {
 		}
 	}
 }
+
+// UpstreamContentTypeHeader carries original content type of captured response body.
+const UpstreamContentTypeHeader = "X-Upstream-Content-Type"
+
+// sendBody writes captured response body as download. Third party content is never
+// rendered by browser from API origin, its original content type goes to separate header.
+func sendBody(w http.ResponseWriter, resp *model.Response) {
+	if contentType := http.Header(resp.Headers).Get("Content-Type"); len(contentType) > 0 {
+		w.Header().Set(UpstreamContentTypeHeader, contentType)
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", "attachment")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Body-Truncated", strconv.FormatBool(resp.Truncated))
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(resp.Body)
+}
+
+// decodePaginator reads paginator from request body and query parameters.
+// Query parameters override body fields, nil paginator lists all requests.
+func decodePaginator(r *http.Request) (*model.Paginator, error) {
+	paginator := &model.Paginator{}
+	if r.Body != nil {
+		if err := json.NewDecoder(r.Body).Decode(paginator); err != nil && err != io.EOF {
+			return nil, err
+		}
+	}
+	query := r.URL.Query()
+	if cursor := query.Get("cursor"); len(cursor) > 0 {
+		paginator.Cursor = cursor
+	}
+	if sort := query.Get("sort"); len(sort) > 0 {
+		paginator.Sort = model.Sort(sort)
+	}
+	if *paginator == (model.Paginator{}) {
+		return nil, nil
+	}
+	return paginator, nil
+}
+
+// decodeFilter reads filter of listed requests from query parameters.
+// Nil filter matches all requests.
+func decodeFilter(query url.Values) (*model.Filter, error) {
+	filter := &model.Filter{
+		Method:      strings.ToUpper(query.Get("method")),
+		Host:        query.Get("host"),
+		URLPrefix:   query.Get("urlPrefix"),
+		ErrorKind:   model.ErrorKind(query.Get("errorKind")),
+		Search:      query.Get("search"),
+		Header:      query.Get("header"),
+		HeaderValue: query.Get("value"),
+	}
+
+	// Status is either code or class like 2xx
+	if status := strings.ToLower(query.Get("status")); len(status) > 0 {
+		if len(status) == 3 && strings.HasSuffix(status, "xx") && status[0] >= '1' && status[0] <= '5' {
+			filter.StatusClass = int(status[0] - '0')
+		} else {
+			code, err := strconv.Atoi(status)
+			if err != nil || code < 100 || code > 599 {
+				return nil, &ParameterError{
+					Name: "status",
+					Err:  errors.Errorf("%s is neither status code nor class", status),
+				}
+			}
+			filter.Status = code
+		}
+	}
+
+	var err error
+	if filter.CreatedFrom, err = parseTime(query.Get("createdFrom")); err != nil {
+		return nil, &ParameterError{Name: "createdFrom", Err: err}
+	}
+	if filter.CreatedTo, err = parseTime(query.Get("createdTo")); err != nil {
+		return nil, &ParameterError{Name: "createdTo", Err: err}
+	}
+
+	if *filter == (model.Filter{}) {
+		return nil, nil
+	}
+	return filter, nil
+}
+
+// parseTime parses RFC 3339 time, empty value is zero time.
+func parseTime(value string) (time.Time, error) {
+	if len(value) == 0 {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// sendPage writes requests of page, total number and next page cursor go to headers.
+func sendPage(w http.ResponseWriter, page *model.Page) {
+	w.Header().Set("X-Total-Count", strconv.Itoa(page.Total))
+	if len(page.NextCursor) > 0 {
+		w.Header().Set("X-Next-Cursor", page.NextCursor)
+	}
+	respond(w, http.StatusOK, page.Requests)
+}
+
+// observeResult counts request as completed or failed by result of fetching external resource.
+// Fetch data rejected by fetcher fails with reason "rejected".
+func observeResult(m *metrics.Metrics, data *model.FetchData, resp *model.Response, err error) {
+	switch {
+	case err != nil:
+		m.Failed(data.Method, "rejected")
+	case resp.Error != nil:
+		m.Failed(data.Method, string(resp.Error.Kind))
+	default:
+		m.Completed(data.Method, resp.Status)
+	}
+}
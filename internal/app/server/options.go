@@ -0,0 +1,19 @@
+package server
+
+import (
+	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/limiter"
+	"github.com/ahamtat/itvbackend/internal/app/metrics"
+)
+
+// Options holds optional dependencies of servers, zero options are valid.
+type Options struct {
+	// Circuit breaker used by fetcher is reported by admin endpoint, nil breaker reports no circuits
+	Breaker *fetcher.BreakerFetcher
+	// Metrics endpoint is served unless metrics are nil
+	Metrics *metrics.Metrics
+	// Health endpoints run its checks, nil health has no checks
+	Health *Health
+	// Limiter of requests to external hosts used by ConcurrentServer, nil limiter does not limit them
+	Limiter *limiter.HostLimiter
+}
@@ -0,0 +1,357 @@
+package server
+
+import (
+	"context"
+	"math"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ahamtat/itvbackend/internal/app/queue"
+	"github.com/ahamtat/itvbackend/internal/app/storage"
+	"github.com/ahamtat/itvbackend/internal/app/tracing"
+	"github.com/pkg/errors"
+)
+
+// ErrQueueFull is returned when task queue has no room for new task.
+var ErrQueueFull = errors.New("task queue is full")
+
+// ErrShuttingDown is returned for task sent to queue during shutdown.
+var ErrShuttingDown = errors.New("task queue is shut down")
+
+// QueueSettings of ConcurrentServer task queue.
+type QueueSettings struct {
+	// Queue capacity, zero is pool size
+	Size int
+	// Time of waiting for room in full queue, zero rejects task at once
+	Wait time.Duration
+	// Delay suggested to clients of rejected tasks
+	RetryAfter time.Duration
+
+	// Durable queue shared by application instances, nil keeps tasks in memory only
+	Jobs queue.Queue
+	// Interval of polling durable queue for jobs pushed by other instances
+	Poll time.Duration
+	// Interval of renewing leases of claimed jobs, it must be less than lease
+	Renew time.Duration
+	// Claims of job before it is failed, zero is unlimited
+	MaxClaims int
+	// Jobs waiting for claim in durable queue before new tasks are rejected, zero is queue capacity
+	MaxJobs int
+}
+
+// DefaultQueueSettings wait a second for room in full queue.
+var DefaultQueueSettings = QueueSettings{
+	Wait:       time.Second,
+	RetryAfter: time.Second,
+	Poll:       time.Second,
+	Renew:      10 * time.Second,
+	MaxClaims:  5,
+}
+
+// QueueStats of ConcurrentServer task queue.
+type QueueStats struct {
+	Depth    int `json:"depth"`
+	Capacity int `json:"capacity"`
+	// Tasks waiting for saturated hosts, they are counted in queue depth
+	Waiting int `json:"waiting,omitempty"`
+	// Jobs in durable queue including claimed ones
+	Jobs int `json:"jobs,omitempty"`
+	// Jobs claimed by this instance
+	Claimed int `json:"claimed,omitempty"`
+	// Jobs waiting for claim in durable queue
+	Pending int `json:"pending,omitempty"`
+}
+
+// jobRoomPoll is interval of checking room in full durable queue.
+const jobRoomPoll = 50 * time.Millisecond
+
+// enqueue sends task to queue waiting for room within queue settings.
+// Waiting is stopped when client disconnects.
+// Task is pushed to durable queue if it is set.
+func (s *ConcurrentServer) enqueue(ctx context.Context, t *task) error {
+	if s.queue.Jobs != nil {
+		if err := s.waitJobRoom(ctx); err != nil {
+			return err
+		}
+		job := queue.Job{ID: t.id, RequestID: t.requestID, TraceParent: t.traceParent}
+		if err := s.queue.Jobs.Push(job); err != nil {
+			return err
+		}
+		// Wake up feeder without waiting for next poll
+		select {
+		case s.pushedCh <- struct{}{}:
+		default:
+		}
+		return nil
+	}
+
+	// Room is freed when task is processed, so tasks waiting for hosts are counted as queued.
+	// Lock is not held while waiting, otherwise waiting handlers would delay shutdown.
+	if err := s.enter(ctx); err != nil {
+		return err
+	}
+
+	// Task channel is closed on shutdown, it has room for every task holding room of queue
+	s.closedMx.RLock()
+	defer s.closedMx.RUnlock()
+	if s.closed {
+		<-s.room
+		return ErrShuttingDown
+	}
+	t.room = true
+	s.tasks.Add(1)
+	s.taskCh <- t
+	return nil
+}
+
+// enter takes room of queue waiting for it within queue settings.
+// Waiting is stopped on shutdown.
+func (s *ConcurrentServer) enter(ctx context.Context) error {
+	select {
+	case <-s.closing:
+		return ErrShuttingDown
+	default:
+	}
+	select {
+	case s.room <- struct{}{}:
+		return nil
+	default:
+	}
+	if s.queue.Wait <= 0 {
+		return ErrQueueFull
+	}
+
+	timer := time.NewTimer(s.queue.Wait)
+	defer timer.Stop()
+	select {
+	case s.room <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return ErrQueueFull
+	case <-s.closing:
+		return ErrShuttingDown
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// waitJobRoom waits until number of jobs waiting for claim in durable queue
+// is below limit, durable queue is shared by instances so it is polled.
+func (s *ConcurrentServer) waitJobRoom(ctx context.Context) error {
+	full, err := s.jobsFull()
+	if err != nil || !full {
+		return err
+	}
+	if s.queue.Wait <= 0 {
+		return ErrQueueFull
+	}
+
+	timer := time.NewTimer(s.queue.Wait)
+	defer timer.Stop()
+	ticker := time.NewTicker(jobRoomPoll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if full, err = s.jobsFull(); err != nil || !full {
+				return err
+			}
+		case <-timer.C:
+			return ErrQueueFull
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// jobsFull reports whether durable queue has no room for new job.
+func (s *ConcurrentServer) jobsFull() (bool, error) {
+	pending, err := s.queue.Jobs.Pending()
+	if err != nil {
+		return false, err
+	}
+	return pending >= s.queue.MaxJobs, nil
+}
+
+// feeder claims jobs from durable queue while there is room for them.
+func (s *ConcurrentServer) feeder() {
+	defer s.feedWG.Done()
+
+	ticker := time.NewTicker(s.queue.Poll)
+	defer ticker.Stop()
+	for {
+		s.claim()
+		select {
+		case <-s.pushedCh:
+		case <-ticker.C:
+		case <-s.stopFeed:
+			return
+		}
+	}
+}
+
+// claim jobs from durable queue and send them to task channel.
+// Claimed jobs are limited by room in worker pool and task channel.
+func (s *ConcurrentServer) claim() {
+	s.heldMx.Lock()
+	limit := s.poolSize + cap(s.taskCh) - len(s.held)
+	s.heldMx.Unlock()
+	if limit <= 0 {
+		return
+	}
+
+	jobs, err := s.queue.Jobs.Claim(limit)
+	if err != nil {
+		s.logger.Errorf("claim(): error claiming jobs from queue: %s", err)
+		return
+	}
+	for _, job := range jobs {
+		s.hold(job.ID, true)
+		t := &task{id: job.ID, requestID: job.RequestID, traceParent: job.TraceParent, job: true}
+		s.tasks.Add(1)
+
+		req, err := tracing.NewStorage(t.context(), s.storage).GetRequest(job.ID)
+		switch {
+		case errors.Is(err, storage.ErrRequestNotFound):
+			// Request was deleted while waiting in queue
+			s.done(t)
+			continue
+		case err != nil:
+			s.taskLog(t).Errorf("claim(): error reading request from storage: %s", err)
+			s.release(t)
+			continue
+		case s.queue.MaxClaims > 0 && job.Claims > s.queue.MaxClaims:
+			// Job was abandoned by crashed instances too many times
+			s.failRequest(t.context(), t, errors.Errorf("job is abandoned after %d claims", s.queue.MaxClaims))
+			s.done(t)
+			continue
+		}
+		if job.Claims > 1 {
+			s.taskLog(t).Infof("resuming job claimed %d times", job.Claims)
+		}
+		t.data = req.Fetch
+		t.queued = time.Now()
+		s.taskCh <- t
+	}
+}
+
+// renewer extends leases of claimed jobs until workers exit.
+func (s *ConcurrentServer) renewer() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.queue.Renew)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			s.heldMx.Lock()
+			ids := make([]string, 0, len(s.held))
+			for id := range s.held {
+				ids = append(ids, id)
+			}
+			s.heldMx.Unlock()
+
+			if err := s.queue.Jobs.Renew(ids); err != nil {
+				s.logger.Errorf("renewer(): error renewing leases of jobs: %s", err)
+			}
+		case <-s.quit:
+			return
+		}
+	}
+}
+
+// hold marks job as claimed by this instance.
+func (s *ConcurrentServer) hold(id string, held bool) {
+	s.heldMx.Lock()
+	defer s.heldMx.Unlock()
+	if held {
+		s.held[id] = true
+	} else {
+		delete(s.held, id)
+	}
+}
+
+// done finishes task removing its job from durable queue.
+func (s *ConcurrentServer) done(t *task) {
+	defer s.tasks.Done()
+	if !t.job {
+		return
+	}
+	if err := s.queue.Jobs.Complete(t.id); err != nil {
+		s.taskLog(t).Errorf("done(): error completing job: %s", err)
+	}
+	s.hold(t.id, false)
+}
+
+// release returns unprocessed task to durable queue.
+func (s *ConcurrentServer) release(t *task) {
+	defer s.tasks.Done()
+	if err := s.queue.Jobs.Release(t.id); err != nil {
+		s.taskLog(t).Errorf("release(): error releasing job: %s", err)
+	}
+	s.hold(t.id, false)
+}
+
+// retryAfter returns Retry-After header value in whole seconds.
+func (s *ConcurrentServer) retryAfter() string {
+	seconds := math.Ceil(s.queue.RetryAfter.Seconds())
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(int(seconds))
+}
+
+// queueStats returns current state of task queue.
+func (s *ConcurrentServer) queueStats() QueueStats {
+	stats := QueueStats{
+		Depth:    len(s.taskCh),
+		Capacity: cap(s.taskCh),
+		Waiting:  int(atomic.LoadInt64(&s.waiting)),
+	}
+	if s.queue.Jobs == nil {
+		stats.Depth = len(s.room)
+	}
+	if s.queue.Jobs != nil {
+		jobs, err := s.queue.Jobs.Depth()
+		if err != nil {
+			s.logger.Errorf("queueStats(): error reading durable queue depth: %s", err)
+		}
+		stats.Jobs = jobs
+
+		pending, err := s.queue.Jobs.Pending()
+		if err != nil {
+			s.logger.Errorf("queueStats(): error reading durable queue pending jobs: %s", err)
+		}
+		stats.Pending = pending
+
+		s.heldMx.Lock()
+		stats.Claimed = len(s.held)
+		s.heldMx.Unlock()
+	}
+	return stats
+}
+
+// checkWorkers fails when some workers of pool have exited.
+func (s *ConcurrentServer) checkWorkers(context.Context) error {
+	if alive := atomic.LoadInt64(&s.alive); alive < int64(s.poolSize) {
+		return errors.Errorf("%d of %d workers are alive", alive, s.poolSize)
+	}
+	return nil
+}
+
+// checkQueue fails when task queue has no room for new task.
+// Durable queue is checked for jobs waiting for claim since in-memory tasks are claimed ones.
+func (s *ConcurrentServer) checkQueue(context.Context) error {
+	if s.queue.Jobs != nil {
+		full, err := s.jobsFull()
+		if err == nil && full {
+			err = ErrQueueFull
+		}
+		return err
+	}
+	if len(s.room) >= cap(s.room) {
+		return ErrQueueFull
+	}
+	return nil
+}
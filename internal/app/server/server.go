@@ -1,12 +1,15 @@
 package server
 
 import (
-	"encoding/json"
+	"context"
 	"net/http"
 
 	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/logging"
+	"github.com/ahamtat/itvbackend/internal/app/metrics"
 	"github.com/ahamtat/itvbackend/internal/app/model"
 	"github.com/ahamtat/itvbackend/internal/app/storage"
+	"github.com/ahamtat/itvbackend/internal/app/tracing"
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
 )
@@ -14,138 +17,182 @@ import (
 // Server holds data for application logic
 type Server struct {
 	router  *mux.Router
+	handler http.Handler
 	logger  *logrus.Logger
 	fetcher fetcher.Fetcher
+	breaker *fetcher.BreakerFetcher
 	storage storage.Storage
+	metrics *metrics.Metrics
+	health  *Health
 }
 
-// NewServer constructor.
-func NewServer(fetcher fetcher.Fetcher, storage storage.Storage) http.Handler {
-	logger := logrus.New()
+// NewServer constructor, limiter of options is not used since requests are fetched at once.
+func NewServer(fetcher fetcher.Fetcher, storage storage.Storage, options Options) http.Handler {
+	logger := logging.NewLogger()
 
 	// Check input data
 	if fetcher == nil || storage == nil {
 		logger.Fatalf("NewServer(): invalid input data")
 	}
+	health := options.Health
+	if health == nil {
+		health = NewHealth(logger)
+	}
 
 	s := &Server{
 		router:  mux.NewRouter(),
 		logger:  logger,
-		fetcher: fetcher,
+		fetcher: tracing.NewFetcher(fetcher),
+		breaker: options.Breaker,
 		storage: storage,
+		metrics: options.Metrics,
+		health:  health,
 	}
 
 	s.configureRouter()
+	s.configureRouterV2()
+	s.handler = handleTrace(s.router, handleAccess(s.logger, s.router))
 	return s
 }
 
 // ServeHTTP implementation for external handler.
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	s.router.ServeHTTP(w, r)
+	s.handler.ServeHTTP(w, r)
+}
+
+// log returns log entry with correlation ID of API call.
+func (s *Server) log(ctx context.Context) *logrus.Entry {
+	return logging.Entry(s.logger, ctx)
+}
+
+// store returns storage recording operations in trace of API call.
+func (s *Server) store(ctx context.Context) storage.Storage {
+	return tracing.NewStorage(ctx, s.storage)
 }
 
 func (s *Server) configureRouter() {
 	requests := s.router.PathPrefix("/v1/requests").Subrouter()
-	requests.HandleFunc("/request", s.handleRequest()).Methods("POST", "DELETE")
-	requests.HandleFunc("/list", s.handleListAllRequests()).Methods("GET")
+	requests.HandleFunc("/request", handleRequest(s, s.makeRequest)).Methods("POST", "DELETE")
+	requests.HandleFunc("/list", handleListAllRequests(s)).Methods("GET")
+	requests.HandleFunc("/{id}", handleGetRequest(s)).Methods("GET")
+	requests.HandleFunc("/{id}/body", handleGetResponseBody(s)).Methods("GET")
+
+	admin := s.router.PathPrefix("/v1/admin").Subrouter()
+	admin.HandleFunc("/breakers", handleListBreakers(s.breaker)).Methods("GET")
+
+	s.router.HandleFunc("/healthz", handleLiveness(s.health)).Methods("GET")
+	s.router.HandleFunc("/readyz", handleReadiness(s.health)).Methods("GET")
+
+	if s.metrics != nil {
+		s.router.Handle("/metrics", s.metrics.Handler()).Methods("GET")
+	}
+}
+
+// configureRouterV2 adds RESTful API with request IDs in path.
+func (s *Server) configureRouterV2() {
+	requests := s.router.PathPrefix("/v2/requests").Subrouter()
+	requests.HandleFunc("", s.handleCreateRequestV2()).Methods("POST")
+	requests.HandleFunc("", handleListRequestsV2(s)).Methods("GET")
+	requests.HandleFunc("/{id}", handleGetRequestV2(s)).Methods("GET")
+	requests.HandleFunc("/{id}", handleDeleteRequestV2(s)).Methods("DELETE")
 }
 
-func (s *Server) handleRequest() http.HandlerFunc {
+// handleCreateRequestV2 fetches external resource and returns created request with response.
+func (s *Server) handleCreateRequestV2() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		switch r.Method {
-		case http.MethodPost:
-			s.makeRequest(w, r)
-		case http.MethodDelete:
-			s.deleteRequest(w, r)
+		data, err := decodeFetchData(r)
+		if err != nil {
+			s.log(r.Context()).Errorf("handleCreateRequestV2(): invalid fetch data: %s", err)
+			sendError(w, err)
+			return
 		}
+
+		resp, err := s.execute(r.Context(), data)
+		if resp != nil {
+			w.Header().Set("Location", requestLocationV2(resp.ID))
+		}
+		if err != nil {
+			sendError(w, err)
+			return
+		}
+
+		// Stored request holds processing state and response
+		req, err := s.store(r.Context()).GetRequest(resp.ID)
+		if err != nil {
+			s.log(r.Context()).Errorf("handleCreateRequestV2(): error reading request from storage: %s", err)
+			sendError(w, err)
+			return
+		}
+		respond(w, http.StatusCreated, req)
 	}
 }
 
 func (s *Server) makeRequest(w http.ResponseWriter, r *http.Request) {
-	if r.Body == nil {
-		s.logger.Errorln("makeRequest(): invalid request body")
-		sendError(w, http.StatusBadRequest, nil)
+	data, err := decodeFetchData(r)
+	if err != nil {
+		s.log(r.Context()).Errorf("makeRequest(): invalid fetch data: %s", err)
+		sendError(w, err)
 		return
 	}
-	data := &model.FetchData{}
-	if err := json.NewDecoder(r.Body).Decode(data); err != nil {
-		s.logger.Errorf("makeRequest(): error decoding request body: %s", err)
-		sendError(w, http.StatusInternalServerError, err)
+
+	resp, err := s.execute(r.Context(), data)
+	if err != nil {
+		sendError(w, err)
 		return
 	}
 
+	// Return response to client
+	respond(w, http.StatusOK, resp)
+}
+
+// execute saves request to storage and fetches response from external resource.
+// On failure response carries only ID of saved request, it is nil if request was not saved.
+func (s *Server) execute(ctx context.Context, data *model.FetchData) (*model.Response, error) {
 	// Save request to storage
-	ID, err := s.storage.AddRequest(data)
+	ID, err := s.store(ctx).AddRequest(data)
 	if err != nil {
-		s.logger.Errorf("makeRequest(): error saving request to storage: %s", err)
-		sendError(w, http.StatusInternalServerError, err)
-		return
+		s.log(ctx).Errorf("execute(): error saving request to storage: %s", err)
+		return nil, err
 	}
+	logging.SetRequestUUID(ctx, ID)
+	log := s.log(ctx)
+
+	s.metrics.Accepted(data.Method)
+	s.updateState(ctx, ID, model.StateRunning)
 
 	// Fetch response from external resource
-	resp, err := s.fetcher.Fetch(ID, data)
+	resp, err := s.fetcher.Fetch(ctx, ID, data)
+	observeResult(s.metrics, data, resp, err)
 	if err != nil {
-		s.logger.Errorf("makeRequest(): error fetching response from external resource: %s", err)
-		sendError(w, http.StatusInternalServerError, err)
-		return
+		log.Errorf("execute(): error fetching response from external resource: %s", err)
+		s.failRequest(ctx, ID, err)
+		return &model.Response{ID: ID}, err
 	}
 
 	// Save response to storage
-	if err := s.storage.AddResponse(ID, resp); err != nil {
-		s.logger.Errorf("makeRequest(): error saving response to storage: %s", err)
-		sendError(w, http.StatusInternalServerError, err)
-		return
+	if err := s.store(ctx).AddResponse(ID, resp); err != nil {
+		log.Errorf("execute(): error saving response to storage: %s", err)
+		s.failRequest(ctx, ID, err)
+		return &model.Response{ID: ID}, err
 	}
 
-	// Return response to client
-	respond(w, http.StatusOK, resp)
-}
-
-func (s *Server) deleteRequest(w http.ResponseWriter, r *http.Request) {
-	if r.Body == nil {
-		s.logger.Errorln("deleteRequest(): invalid request body")
-		sendError(w, http.StatusBadRequest, nil)
-		return
-	}
-	type request struct {
-		ID string `json:"id"`
-	}
-	data := &request{}
-	if err := json.NewDecoder(r.Body).Decode(data); err != nil {
-		s.logger.Errorf("deleteRequest(): error decoding request body: %s", err)
-		sendError(w, http.StatusBadRequest, err)
-		return
+	// Request without HTTP response from external resource is failed
+	if resp.Error != nil {
+		s.failRequest(ctx, ID, resp.Error)
+	} else {
+		s.updateState(ctx, ID, model.StateSucceeded)
 	}
+	return resp, nil
+}
 
-	// Delete request from storage
-	if err := s.storage.DeleteRequest(data.ID); err != nil {
-		s.logger.Errorf("deleteRequest(): error saving request to storage: %s", err)
-		sendError(w, http.StatusInternalServerError, err)
-		return
+func (s *Server) updateState(ctx context.Context, id string, state model.State) {
+	if err := s.store(ctx).UpdateState(id, state); err != nil {
+		s.log(ctx).Errorf("updateState(): error saving request state %s to storage: %s", state, err)
 	}
-
-	// Send success to client
-	respond(w, http.StatusOK, nil)
 }
 
-func (s *Server) handleListAllRequests() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		paginator := &model.Paginator{}
-		if r.Body == nil {
-			paginator = nil
-		} else {
-			if err := json.NewDecoder(r.Body).Decode(paginator); err != nil {
-				s.logger.Errorf("handleListAllRequests(): error decoding request body: %s", err)
-				sendError(w, http.StatusBadRequest, err)
-				return
-			}
-			if paginator.RequestsPerPage == 0 {
-				paginator = nil
-			}
-		}
-
-		// Get stored requests
-		requests := s.storage.GetAllRequests(paginator)
-		respond(w, http.StatusOK, requests)
+func (s *Server) failRequest(ctx context.Context, id string, reason error) {
+	if err := s.store(ctx).FailRequest(id, reason.Error()); err != nil {
+		s.log(ctx).Errorf("failRequest(): error saving request failure to storage: %s", err)
 	}
 }
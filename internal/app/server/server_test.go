@@ -2,19 +2,26 @@ package server_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/ahamtat/itvbackend/internal/app/storage"
 	"github.com/ahamtat/itvbackend/internal/app/storage/memory"
+	"github.com/google/uuid"
+	pkgerrors "github.com/pkg/errors"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/ahamtat/itvbackend/internal/app/server"
 
 	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/metrics"
 	"github.com/ahamtat/itvbackend/internal/app/model"
 	"github.com/stretchr/testify/require"
 )
@@ -40,26 +47,40 @@ var fetchData = []model.FetchData{
 	},
 }
 
-func populateStorage(s http.Handler, t *testing.T) {
-	// Populate storage with responses
-	for _, d := range fetchData {
-		body, err := json.Marshal(d)
-		require.Nil(t, err)
+func postRequest(s http.Handler, data *model.FetchData, expected int, t *testing.T) string {
+	body, err := json.Marshal(data)
+	require.Nil(t, err)
 
-		rec := httptest.NewRecorder()
-		req, err := http.NewRequest(http.MethodPost, "/v1/requests/request", bytes.NewReader(body))
-		require.Nil(t, err)
-		s.ServeHTTP(rec, req)
-		require.Equal(t, http.StatusOK, rec.Code)
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/v1/requests/request", bytes.NewReader(body))
+	require.Nil(t, err)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, expected, rec.Code)
+
+	// Decode generated ID
+	result := &model.Request{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), result))
+	require.NotEmpty(t, result.ID)
+	return result.ID
+}
+
+func populateStorage(s http.Handler, expected int, t *testing.T) []string {
+	generatedID := make([]string, 0, len(fetchData))
+
+	// Populate storage with responses
+	for i := range fetchData {
+		generatedID = append(generatedID, postRequest(s, &fetchData[i], expected, t))
 	}
+	return generatedID
 }
 
 func TestServer_FetchResponse(t *testing.T) {
 	s := server.NewServer(
 		fetcher.NewMockFetcher(),
-		memory.NewMemoryStorage())
+		memory.NewMemoryStorage(),
+		server.Options{})
 
-	populateStorage(s, t)
+	populateStorage(s, http.StatusOK, t)
 }
 
 func readAndDecodeRequests(s http.Handler, expected int, paginator *model.Paginator, t *testing.T) []model.Request {
@@ -89,47 +110,448 @@ func readAndDecodeRequests(s http.Handler, expected int, paginator *model.Pagina
 func TestServer_ListResponse(t *testing.T) {
 	s := server.NewServer(
 		fetcher.NewMockFetcher(),
-		memory.NewMemoryStorage())
+		memory.NewMemoryStorage(),
+		server.Options{})
 
 	// Read All data
-	populateStorage(s, t)
+	populateStorage(s, http.StatusOK, t)
 	_ = readAndDecodeRequests(s, len(fetchData), nil, t)
 
 	// Read with paginator
-	populateStorage(s, t)
+	populateStorage(s, http.StatusOK, t)
 	_ = readAndDecodeRequests(s, 2, &model.Paginator{
 		Page:            0,
 		RequestsPerPage: 2,
 	}, t)
 }
 
-func TestServer_DeleteResponse(t *testing.T) {
+func TestServer_ListCursor(t *testing.T) {
 	s := server.NewServer(
 		fetcher.NewMockFetcher(),
-		memory.NewMemoryStorage())
-
-	populateStorage(s, t)
-	requests := readAndDecodeRequests(s, len(fetchData), nil, t)
+		memory.NewMemoryStorage(),
+		server.Options{})
+	generatedID := populateStorage(s, http.StatusOK, t)
 
-	// Delete responses from storage
-	for _, req := range requests {
-		// Create body with ID
-		type requestBody struct {
-			ID string `json:"id"`
-		}
-		reqBody := &requestBody{ID: req.Response.ID}
-		assert.NotEmpty(t, reqBody.ID)
-		body, err := json.Marshal(reqBody)
+	list := func(body, query string, expected int) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req, err := http.NewRequest(http.MethodGet, "/v1/requests/list"+query, bytes.NewBufferString(body))
 		require.Nil(t, err)
+		s.ServeHTTP(rec, req)
+		require.Equal(t, expected, rec.Code)
+		return rec
+	}
 
+	// Newest requests first, cursor of next page is sent in header
+	rec := list(`{"requestsPerPage":2}`, "?sort=-createdAt", http.StatusOK)
+	require.Equal(t, "3", rec.Header().Get("X-Total-Count"))
+	cursor := rec.Header().Get("X-Next-Cursor")
+	require.NotEmpty(t, cursor)
+	var result []model.Request
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.Equal(t, 2, len(result))
+	require.Equal(t, generatedID[2], result[0].ID)
+	require.False(t, result[0].CreatedAt.IsZero())
+
+	// Last page has no cursor
+	rec = list(`{"requestsPerPage":2}`, "?sort=-createdAt&cursor="+cursor, http.StatusOK)
+	require.Empty(t, rec.Header().Get("X-Next-Cursor"))
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.Equal(t, 1, len(result))
+	require.Equal(t, generatedID[0], result[0].ID)
+
+	// Invalid paginator
+	list("", "?sort=url", http.StatusBadRequest)
+	list("", "?cursor=garbage", http.StatusBadRequest)
+	list(`{"requestsPerPage":1}`, "?cursor="+cursor, http.StatusBadRequest)
+}
+
+func TestServer_ListFilter(t *testing.T) {
+	s := server.NewServer(
+		fetcher.NewMockFetcher(),
+		memory.NewMemoryStorage(),
+		server.Options{})
+	generatedID := []string{
+		postRequest(s, &model.FetchData{Method: "GET", URL: "http://google.com/search"}, http.StatusOK, t),
+		postRequest(s, &model.FetchData{Method: "POST", URL: "http://example.com/items"}, http.StatusOK, t),
+	}
+
+	list := func(query string, expected int) []model.Request {
 		rec := httptest.NewRecorder()
-		req, err := http.NewRequest(http.MethodDelete, "/v1/requests/request", bytes.NewReader(body))
+		req, err := http.NewRequest(http.MethodGet, "/v1/requests/list"+query, nil)
 		require.Nil(t, err)
 		s.ServeHTTP(rec, req)
-		require.Equal(t, http.StatusOK, rec.Code)
+		require.Equal(t, expected, rec.Code)
+
+		var result []model.Request
+		if expected == http.StatusOK {
+			require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+		}
+		return result
 	}
 
+	result := list("?method=post", http.StatusOK)
+	require.Equal(t, 1, len(result))
+	require.Equal(t, generatedID[1], result[0].ID)
+	result = list("?host=google.com&status=2xx&search=SEARCH", http.StatusOK)
+	require.Equal(t, 1, len(result))
+	require.Equal(t, generatedID[0], result[0].ID)
+	require.Equal(t, 2, len(list("?status=200&createdFrom=2020-01-01T00:00:00Z", http.StatusOK)))
+	require.Empty(t, list("?errorKind=timeout", http.StatusOK))
+
+	// Invalid filter
+	list("?status=2x", http.StatusBadRequest)
+	list("?status=600", http.StatusBadRequest)
+	list("?createdTo=yesterday", http.StatusBadRequest)
+}
+
+func deleteRequest(s http.Handler, ID string, expected int, t *testing.T) {
+	// Create body with ID
+	type requestBody struct {
+		ID string `json:"id"`
+	}
+	reqBody := &requestBody{ID: ID}
+	assert.NotEmpty(t, reqBody.ID)
+	body, err := json.Marshal(reqBody)
+	require.Nil(t, err)
+
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodDelete, "/v1/requests/request", bytes.NewReader(body))
+	require.Nil(t, err)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, expected, rec.Code)
+}
+
+func TestServer_DeleteResponse(t *testing.T) {
+	s := server.NewServer(
+		fetcher.NewMockFetcher(),
+		memory.NewMemoryStorage(),
+		server.Options{})
+
+	populateStorage(s, http.StatusOK, t)
+	requests := readAndDecodeRequests(s, len(fetchData), nil, t)
+
+	// Delete responses from storage
+	for _, req := range requests {
+		deleteRequest(s, req.Response.ID, http.StatusOK, t)
+	}
+
+	// Delete non-existing response
+	deleteRequest(s, uuid.New().String(), http.StatusNotFound, t)
+
 	// Check if storage is empty
 	emptyRequest := readAndDecodeRequests(s, 0, nil, t)
 	require.Empty(t, emptyRequest)
 }
+
+func getRequest(s http.Handler, ID string, expected int, t *testing.T) *model.Request {
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/v1/requests/"+ID, nil)
+	require.Nil(t, err)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, expected, rec.Code)
+
+	result := &model.Request{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), result))
+	return result
+}
+
+func TestServer_GetResponse(t *testing.T) {
+	s := server.NewServer(
+		fetcher.NewMockFetcher(),
+		memory.NewMemoryStorage(),
+		server.Options{})
+
+	for _, ID := range populateStorage(s, http.StatusOK, t) {
+		req := getRequest(s, ID, http.StatusOK, t)
+		require.Equal(t, ID, req.ID)
+		require.Equal(t, model.StateSucceeded, req.State)
+		require.NotNil(t, req.Response)
+	}
+
+	// Get non-existing request
+	getRequest(s, uuid.New().String(), http.StatusNotFound, t)
+}
+
+// htmlFetcher returns page of third party HTML.
+type htmlFetcher struct{}
+
+func (htmlFetcher) Fetch(ctx context.Context, id string, data *model.FetchData) (*model.Response, error) {
+	body := []byte("<script>alert(document.cookie)</script>")
+	return &model.Response{
+		ID:      id,
+		Status:  http.StatusOK,
+		Headers: map[string][]string{"Content-Type": {"text/html"}},
+		Length:  int64(len(body)),
+		Body:    body,
+	}, nil
+}
+
+func TestServer_GetResponseBody(t *testing.T) {
+	s := server.NewServer(
+		htmlFetcher{},
+		memory.NewMemoryStorage(),
+		server.Options{})
+
+	testCases := []struct {
+		name     string
+		capture  bool
+		expected int
+	}{
+		{
+			name:     "Captured body",
+			capture:  true,
+			expected: http.StatusOK,
+		},
+		{
+			name:     "Not captured body",
+			capture:  false,
+			expected: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ID := postRequest(s, &model.FetchData{
+				Method: "GET",
+				URL:    "http://google.com",
+				FetchOptions: model.FetchOptions{
+					CaptureBody: tc.capture,
+				},
+			}, http.StatusOK, t)
+
+			rec := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/v1/requests/"+ID+"/body", nil)
+			require.Nil(t, err)
+			s.ServeHTTP(rec, req)
+			require.Equal(t, tc.expected, rec.Code)
+			if tc.expected != http.StatusOK {
+				return
+			}
+
+			// Captured page is downloaded, never rendered from API origin
+			require.Equal(t, "application/octet-stream", rec.Header().Get("Content-Type"))
+			require.Equal(t, "text/html", rec.Header().Get(server.UpstreamContentTypeHeader))
+			require.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+			require.Equal(t, "attachment", rec.Header().Get("Content-Disposition"))
+			require.Equal(t, "<script>alert(document.cookie)</script>", rec.Body.String())
+		})
+	}
+}
+
+func TestServer_ListBreakers(t *testing.T) {
+	b := fetcher.NewBreakerFetcher(fetcher.NewMockFetcher(), fetcher.DefaultBreakerSettings)
+	s := server.NewServer(
+		b,
+		memory.NewMemoryStorage(),
+		server.Options{Breaker: b})
+	postRequest(s, &model.FetchData{Method: "GET", URL: "http://google.com"}, http.StatusOK, t)
+
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/v1/admin/breakers", nil)
+	require.Nil(t, err)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	breakers := make(map[string]fetcher.BreakerStats)
+	require.Nil(t, json.NewDecoder(rec.Body).Decode(&breakers))
+	require.Equal(t, 1, len(breakers))
+	require.Equal(t, fetcher.BreakerClosed, breakers["google.com"].State)
+	require.Equal(t, 1, breakers["google.com"].Requests)
+}
+
+func TestServer_ErrorResponse(t *testing.T) {
+	s := server.NewServer(
+		fetcher.NewMockFetcher(),
+		memory.NewMemoryStorage(),
+		server.Options{})
+
+	testCases := []struct {
+		name     string
+		method   string
+		target   string
+		body     string
+		expected server.ErrorResponse
+	}{
+		{
+			name:     "malformed body",
+			method:   http.MethodPost,
+			target:   "/v1/requests/request",
+			body:     `{"method":`,
+			expected: server.ErrorResponse{Status: http.StatusBadRequest, Code: server.CodeMalformedBody},
+		},
+		{
+			name:     "wrong method",
+			method:   http.MethodPost,
+			target:   "/v1/requests/request",
+			body:     `{"method":"BREW","url":"http://google.com"}`,
+			expected: server.ErrorResponse{Status: http.StatusUnprocessableEntity, Code: server.CodeMethodNotAllowed},
+		},
+		{
+			name:     "request not found",
+			method:   http.MethodGet,
+			target:   "/v1/requests/" + uuid.New().String(),
+			expected: server.ErrorResponse{Status: http.StatusNotFound, Code: server.CodeRequestNotFound},
+		},
+		{
+			name:     "invalid sort",
+			method:   http.MethodGet,
+			target:   "/v1/requests/list?sort=url",
+			expected: server.ErrorResponse{Status: http.StatusBadRequest, Code: server.CodeInvalidSort},
+		},
+		{
+			name:   "invalid parameter",
+			method: http.MethodGet,
+			target: "/v2/requests?status=2x",
+			expected: server.ErrorResponse{
+				Status:  http.StatusBadRequest,
+				Code:    server.CodeInvalidParameter,
+				Details: []server.FieldError{{Field: "status", Message: "2x is neither status code nor class"}},
+			},
+		},
+		{
+			name:   "invalid fetch data",
+			method: http.MethodPost,
+			target: "/v2/requests",
+			body:   `{"url":"google.com","bodyEncoding":"gzip"}`,
+			expected: server.ErrorResponse{
+				Status: http.StatusUnprocessableEntity,
+				Code:   server.CodeValidationFailed,
+				Details: []server.FieldError{
+					{Field: "method", Message: "method is required"},
+					{Field: "url", Message: "absolute URL is required"},
+					{Field: "bodyEncoding", Message: "unknown body encoding"},
+				},
+			},
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req, err := http.NewRequest(tc.method, tc.target, bytes.NewBufferString(tc.body))
+			require.Nil(t, err)
+			s.ServeHTTP(rec, req)
+			require.Equal(t, tc.expected.Status, rec.Code)
+			require.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+
+			result := server.ErrorResponse{}
+			require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+			require.NotEmpty(t, result.Message)
+			result.Message = ""
+			require.Equal(t, tc.expected, result)
+		})
+	}
+}
+
+// brokenStorage fails reading requests with wrapped and unknown errors.
+type brokenStorage struct {
+	storage.Storage
+}
+
+func (s *brokenStorage) GetRequest(id string) (*model.Request, error) {
+	return nil, pkgerrors.Wrapf(storage.ErrRequestNotFound, "reading request %s", id)
+}
+
+func (s *brokenStorage) FindRequests(*model.Filter, *model.Paginator) (*model.Page, error) {
+	return nil, errors.New("pq: password authentication failed for user \"itvbackend\"")
+}
+
+func TestServer_WrappedErrors(t *testing.T) {
+	s := server.NewServer(fetcher.NewMockFetcher(), &brokenStorage{memory.NewMemoryStorage()}, server.Options{})
+
+	// Wrapped sentinel is mapped as the sentinel itself
+	rec := httptest.NewRecorder()
+	req, _ := http.NewRequest(http.MethodGet, "/v1/requests/"+uuid.New().String(), nil)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+	result := server.ErrorResponse{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.Equal(t, server.CodeRequestNotFound, result.Code)
+
+	// Internal error details are not sent to client
+	rec = httptest.NewRecorder()
+	req, _ = http.NewRequest(http.MethodGet, "/v2/requests", nil)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusInternalServerError, rec.Code)
+	result = server.ErrorResponse{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	require.Equal(t, server.ErrorResponse{
+		Status:  http.StatusInternalServerError,
+		Code:    server.CodeInternal,
+		Message: http.StatusText(http.StatusInternalServerError),
+	}, result)
+}
+
+// scrapeMetrics reads metrics exposition from server.
+func scrapeMetrics(s http.Handler, t *testing.T) string {
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+	require.Nil(t, err)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+	return rec.Body.String()
+}
+
+func TestServer_Metrics(t *testing.T) {
+	s := server.NewServer(
+		fetcher.NewMockFetcher(),
+		memory.NewMemoryStorage(),
+		server.Options{Metrics: metrics.NewMetrics()})
+
+	populateStorage(s, http.StatusOK, t)
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(http.MethodPost, "/v1/requests/request",
+		bytes.NewBufferString(`{"method":"BREW","url":"http://google.com"}`))
+	require.Nil(t, err)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	body := scrapeMetrics(s, t)
+	require.Contains(t, body, `itvbackend_requests_accepted_total{method="GET"} 3`)
+	require.Contains(t, body, `itvbackend_requests_accepted_total{method="OTHER"} 1`)
+	require.Contains(t, body, `itvbackend_requests_completed_total{method="GET",status_class="2xx"} 3`)
+	require.Contains(t, body, `itvbackend_requests_failed_total{method="OTHER",reason="rejected"} 1`)
+
+	// Metrics endpoint is not served without metrics
+	s = server.NewServer(fetcher.NewMockFetcher(), memory.NewMemoryStorage(), server.Options{})
+	rec = httptest.NewRecorder()
+	req, err = http.NewRequest(http.MethodGet, "/metrics", nil)
+	require.Nil(t, err)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestServer_RequestID(t *testing.T) {
+	s := server.NewServer(
+		fetcher.NewMockFetcher(),
+		memory.NewMemoryStorage(),
+		server.Options{})
+
+	testCases := []struct {
+		name      string
+		requestID string
+		keep      bool
+	}{
+		{name: "assigned", requestID: ""},
+		{name: "propagated", requestID: "4bf92f35-77b3-4da6", keep: true},
+		{name: "too long", requestID: strings.Repeat("a", 129)},
+		{name: "unsafe", requestID: "id\nforged log line"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			req, err := http.NewRequest(http.MethodGet, "/v2/requests", nil)
+			require.Nil(t, err)
+			req.Header.Set(server.RequestIDHeader, tc.requestID)
+			s.ServeHTTP(rec, req)
+			require.Equal(t, http.StatusOK, rec.Code)
+
+			requestID := rec.Header().Get(server.RequestIDHeader)
+			if tc.keep {
+				require.Equal(t, tc.requestID, requestID)
+				return
+			}
+			_, err = uuid.Parse(requestID)
+			require.Nil(t, err)
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ahamtat/itvbackend/internal/app/tracing"
+)
+
+// serverName is reported in attributes of server spans.
+const serverName = "itvbackend"
+
+// handleTrace records API call as server span continuing trace of client from W3C traceparent header.
+// Span is named by route template, health checks and metrics scrapes are not traced.
+func handleTrace(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/healthz", "/readyz", "/metrics":
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		route := ""
+		match := &mux.RouteMatch{}
+		if router.Match(r, match) && match.Route != nil {
+			route, _ = match.Route.GetPathTemplate()
+		}
+		name := "HTTP " + r.Method
+		if len(route) > 0 {
+			name += " " + route
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(semconv.HTTPServerAttributesFromHTTPRequest(serverName, route, r)...))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(rec.status)...)
+		span.SetAttributes(attribute.String("request.id", w.Header().Get(RequestIDHeader)))
+		// Client errors are not errors of server span
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
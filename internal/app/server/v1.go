@@ -0,0 +1,164 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/logging"
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/ahamtat/itvbackend/internal/app/storage"
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// backend gives handlers shared by servers storage and log entry bound to API call.
+type backend interface {
+	// store returns storage recording operations in trace of API call.
+	store(ctx context.Context) storage.Storage
+	// log returns log entry with correlation ID of API call.
+	log(ctx context.Context) *logrus.Entry
+}
+
+// decodeFetchData reads and validates fetch data from request body.
+func decodeFetchData(r *http.Request) (*model.FetchData, error) {
+	if r.Body == nil {
+		return nil, ErrEmptyBody
+	}
+	data := &model.FetchData{}
+	if err := json.NewDecoder(r.Body).Decode(data); err != nil {
+		return nil, err
+	}
+	if err := validateFetchData(data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// validateFetchData checks fetch data independent of fetcher settings.
+func validateFetchData(data *model.FetchData) error {
+	fields := make([]FieldError, 0)
+	if len(data.Method) == 0 {
+		fields = append(fields, FieldError{Field: "method", Message: "method is required"})
+	}
+	// Allowed URL schemes are checked by egress policy of fetcher
+	if u, err := url.ParseRequestURI(data.URL); err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		fields = append(fields, FieldError{Field: "url", Message: "absolute URL is required"})
+	}
+	switch data.BodyEncoding {
+	case "", model.BodyEncodingText, model.BodyEncodingBase64, model.BodyEncodingForm, model.BodyEncodingMultipart:
+	default:
+		fields = append(fields, FieldError{Field: "bodyEncoding", Message: "unknown body encoding"})
+	}
+	if len(fields) > 0 {
+		return &ValidationError{Fields: fields}
+	}
+	return nil
+}
+
+// handleRequest dispatches request creation to server and deletes requests.
+func handleRequest(b backend, makeRequest http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			makeRequest(w, r)
+		case http.MethodDelete:
+			deleteRequest(b, w, r)
+		}
+	}
+}
+
+func deleteRequest(b backend, w http.ResponseWriter, r *http.Request) {
+	if r.Body == nil {
+		b.log(r.Context()).Errorln("deleteRequest(): invalid request body")
+		sendError(w, ErrEmptyBody)
+		return
+	}
+	type request struct {
+		ID string `json:"id"`
+	}
+	data := &request{}
+	if err := json.NewDecoder(r.Body).Decode(data); err != nil {
+		b.log(r.Context()).Errorf("deleteRequest(): error decoding request body: %s", err)
+		sendError(w, err)
+		return
+	}
+
+	// Delete request from storage
+	logging.SetRequestUUID(r.Context(), data.ID)
+	if err := b.store(r.Context()).DeleteRequest(data.ID); err != nil {
+		b.log(r.Context()).Errorf("deleteRequest(): error deleting request from storage: %s", err)
+		sendError(w, err)
+		return
+	}
+
+	// Send success to client
+	respond(w, http.StatusOK, nil)
+}
+
+func handleListAllRequests(b backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		paginator, err := decodePaginator(r)
+		if err != nil {
+			b.log(r.Context()).Errorf("handleListAllRequests(): error decoding request body: %s", err)
+			sendError(w, err)
+			return
+		}
+
+		filter, err := decodeFilter(r.URL.Query())
+		if err != nil {
+			b.log(r.Context()).Errorf("handleListAllRequests(): error decoding filter: %s", err)
+			sendError(w, err)
+			return
+		}
+
+		// Get stored requests optionally filtered
+		page, err := b.store(r.Context()).FindRequests(filter, paginator)
+		if err != nil {
+			b.log(r.Context()).Errorf("handleListAllRequests(): error reading requests from storage: %s", err)
+			sendError(w, err)
+			return
+		}
+		sendPage(w, page)
+	}
+}
+
+func handleGetRequest(b backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ID := mux.Vars(r)["id"]
+		logging.SetRequestUUID(r.Context(), ID)
+		req, err := b.store(r.Context()).GetRequest(ID)
+		if err != nil {
+			b.log(r.Context()).Errorf("handleGetRequest(): error reading request from storage: %s", err)
+			sendError(w, err)
+			return
+		}
+		respond(w, http.StatusOK, req)
+	}
+}
+
+func handleGetResponseBody(b backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ID := mux.Vars(r)["id"]
+		logging.SetRequestUUID(r.Context(), ID)
+		req, err := b.store(r.Context()).GetRequest(ID)
+		if err != nil {
+			b.log(r.Context()).Errorf("handleGetResponseBody(): error reading request from storage: %s", err)
+			sendError(w, err)
+			return
+		}
+		if req.Response == nil || !req.Fetch.CaptureBody {
+			sendError(w, ErrBodyNotCaptured)
+			return
+		}
+		sendBody(w, req.Response)
+	}
+}
+
+func handleListBreakers(breaker *fetcher.BreakerFetcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, breaker.Stats())
+	}
+}
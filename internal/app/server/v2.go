@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/ahamtat/itvbackend/internal/app/logging"
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/gorilla/mux"
+	"github.com/pkg/errors"
+)
+
+// requestLocationV2 returns URL of request resource in API v2.
+func requestLocationV2(ID string) string {
+	return "/v2/requests/" + ID
+}
+
+// decodeQueryPaginator reads paginator from query parameters.
+// Nil paginator lists all requests.
+func decodeQueryPaginator(query url.Values) (*model.Paginator, error) {
+	paginator := &model.Paginator{
+		Cursor: query.Get("cursor"),
+		Sort:   model.Sort(query.Get("sort")),
+	}
+	var err error
+	if value := query.Get("page"); len(value) > 0 {
+		if paginator.Page, err = strconv.Atoi(value); err != nil {
+			return nil, &ParameterError{Name: "page", Err: errors.Errorf("%s is not integer", value)}
+		}
+	}
+	if value := query.Get("requestsPerPage"); len(value) > 0 {
+		if paginator.RequestsPerPage, err = strconv.Atoi(value); err != nil {
+			return nil, &ParameterError{Name: "requestsPerPage", Err: errors.Errorf("%s is not integer", value)}
+		}
+	}
+	if *paginator == (model.Paginator{}) {
+		return nil, nil
+	}
+	return paginator, nil
+}
+
+func handleListRequestsV2(b backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		paginator, err := decodeQueryPaginator(r.URL.Query())
+		if err != nil {
+			b.log(r.Context()).Errorf("handleListRequestsV2(): error decoding paginator: %s", err)
+			sendError(w, err)
+			return
+		}
+		filter, err := decodeFilter(r.URL.Query())
+		if err != nil {
+			b.log(r.Context()).Errorf("handleListRequestsV2(): error decoding filter: %s", err)
+			sendError(w, err)
+			return
+		}
+
+		page, err := b.store(r.Context()).FindRequests(filter, paginator)
+		if err != nil {
+			b.log(r.Context()).Errorf("handleListRequestsV2(): error reading requests from storage: %s", err)
+			sendError(w, err)
+			return
+		}
+		respond(w, http.StatusOK, page)
+	}
+}
+
+func handleGetRequestV2(b backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ID := mux.Vars(r)["id"]
+		logging.SetRequestUUID(r.Context(), ID)
+		req, err := b.store(r.Context()).GetRequest(ID)
+		if err != nil {
+			b.log(r.Context()).Errorf("handleGetRequestV2(): error reading request from storage: %s", err)
+			sendError(w, err)
+			return
+		}
+		respond(w, http.StatusOK, req)
+	}
+}
+
+func handleDeleteRequestV2(b backend) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ID := mux.Vars(r)["id"]
+		logging.SetRequestUUID(r.Context(), ID)
+		if err := b.store(r.Context()).DeleteRequest(ID); err != nil {
+			b.log(r.Context()).Errorf("handleDeleteRequestV2(): error deleting request from storage: %s", err)
+			sendError(w, err)
+			return
+		}
+		respond(w, http.StatusNoContent, nil)
+	}
+}
@@ -0,0 +1,134 @@
+package server_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/ahamtat/itvbackend/internal/app/server"
+	"github.com/ahamtat/itvbackend/internal/app/storage/memory"
+)
+
+// serveV2 sends request to API v2 and checks status code.
+func serveV2(s http.Handler, method, target, body string, expected int, t *testing.T) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	req, err := http.NewRequest(method, target, bytes.NewBufferString(body))
+	require.Nil(t, err)
+	s.ServeHTTP(rec, req)
+	require.Equal(t, expected, rec.Code, rec.Body.String())
+	return rec
+}
+
+// createRequestV2 posts fetch data and returns created request.
+func createRequestV2(s http.Handler, data *model.FetchData, expected int, t *testing.T) *model.Request {
+	body, err := json.Marshal(data)
+	require.Nil(t, err)
+	rec := serveV2(s, http.MethodPost, "/v2/requests", string(body), expected, t)
+
+	req := &model.Request{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), req))
+	require.NotEmpty(t, req.ID)
+	require.Equal(t, "/v2/requests/"+req.ID, rec.Header().Get("Location"))
+	require.False(t, req.CreatedAt.IsZero())
+	return req
+}
+
+func testRequestsV2(s http.Handler, expected int, t *testing.T) {
+	// Create requests
+	created := make([]*model.Request, 0, len(fetchData))
+	for i := range fetchData {
+		created = append(created, createRequestV2(s, &fetchData[i], expected, t))
+	}
+
+	// Invalid fetch data
+	serveV2(s, http.MethodPost, "/v2/requests", "{", http.StatusBadRequest, t)
+	serveV2(s, http.MethodPost, "/v2/requests", `{"url":"http://google.com"}`, http.StatusUnprocessableEntity, t)
+	serveV2(s, http.MethodPost, "/v2/requests", `{"method":"GET","url":"/search"}`, http.StatusUnprocessableEntity, t)
+	serveV2(s, http.MethodPost, "/v2/requests", `{"method":"GET","url":"http://google.com","bodyEncoding":"gzip"}`,
+		http.StatusUnprocessableEntity, t)
+
+	// List requests by query string pagination
+	rec := serveV2(s, http.MethodGet, "/v2/requests?requestsPerPage=2&sort=-createdAt", "", http.StatusOK, t)
+	page := &model.Page{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), page))
+	require.Equal(t, len(fetchData), page.Total)
+	require.Equal(t, 2, len(page.Requests))
+	require.Equal(t, created[2].ID, page.Requests[0].ID)
+	require.NotEmpty(t, page.NextCursor)
+
+	rec = serveV2(s, http.MethodGet, "/v2/requests?requestsPerPage=2&sort=-createdAt&cursor="+page.NextCursor,
+		"", http.StatusOK, t)
+	page = &model.Page{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), page))
+	require.Equal(t, 1, len(page.Requests))
+	require.Equal(t, created[0].ID, page.Requests[0].ID)
+	require.Empty(t, page.NextCursor)
+
+	serveV2(s, http.MethodGet, "/v2/requests?page=first", "", http.StatusBadRequest, t)
+	serveV2(s, http.MethodGet, "/v2/requests?cursor=garbage", "", http.StatusBadRequest, t)
+
+	// Get and delete request by ID
+	for _, req := range created {
+		rec = serveV2(s, http.MethodGet, "/v2/requests/"+req.ID, "", http.StatusOK, t)
+		stored := &model.Request{}
+		require.Nil(t, json.Unmarshal(rec.Body.Bytes(), stored))
+		require.Equal(t, req.ID, stored.ID)
+
+		serveV2(s, http.MethodDelete, "/v2/requests/"+req.ID, "", http.StatusNoContent, t)
+		serveV2(s, http.MethodGet, "/v2/requests/"+req.ID, "", http.StatusNotFound, t)
+		serveV2(s, http.MethodDelete, "/v2/requests/"+req.ID, "", http.StatusNotFound, t)
+	}
+	serveV2(s, http.MethodGet, "/v2/requests/"+uuid.New().String(), "", http.StatusNotFound, t)
+}
+
+func TestServer_RequestsV2(t *testing.T) {
+	s := server.NewServer(
+		fetcher.NewMockFetcher(),
+		memory.NewMemoryStorage(),
+		server.Options{})
+
+	testRequestsV2(s, http.StatusCreated, t)
+
+	// Created request holds fetched response
+	req := createRequestV2(s, &fetchData[0], http.StatusCreated, t)
+	require.Equal(t, model.StateSucceeded, req.State)
+	require.NotNil(t, req.Response)
+	require.Equal(t, http.StatusOK, req.Response.Status)
+
+	// URL scheme is checked by egress policy of fetcher
+	createRequestV2(s, &model.FetchData{Method: http.MethodGet, URL: "ftp://files.example.com/a"}, http.StatusCreated, t)
+
+	// Method rejected by fetcher is stored as failed request
+	rec := serveV2(s, http.MethodPost, "/v2/requests", `{"method":"BREW","url":"http://google.com"}`,
+		http.StatusUnprocessableEntity, t)
+	location := rec.Header().Get("Location")
+	require.NotEmpty(t, location)
+	rec = serveV2(s, http.MethodGet, location, "", http.StatusOK, t)
+	failed := &model.Request{}
+	require.Nil(t, json.Unmarshal(rec.Body.Bytes(), failed))
+	require.Equal(t, model.StateFailed, failed.State)
+}
+
+func TestConcurrentServer_RequestsV2(t *testing.T) {
+	s := server.NewConcurrentServer(
+		5,
+		server.DefaultQueueSettings,
+		fetcher.NewMockFetcher(),
+		memory.NewMemoryStorage(),
+		server.Options{})
+	defer s.(*server.ConcurrentServer).Close()
+
+	testRequestsV2(s, http.StatusAccepted, t)
+
+	// Accepted request is queued for processing
+	req := createRequestV2(s, &fetchData[0], http.StatusAccepted, t)
+	require.Equal(t, model.StateQueued, req.State)
+	waitForRequests(s, []string{req.ID}, t)
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"time"
+
+	"github.com/ahamtat/itvbackend/internal/app/model"
+)
+
+// Cursor points to last request of page.
+type Cursor struct {
+	CreatedAt time.Time  `json:"t"`
+	Seq       int64      `json:"s"`
+	Sort      model.Sort `json:"o,omitempty"`
+}
+
+// EncodeCursor makes opaque cursor string.
+func EncodeCursor(cursor *Cursor) string {
+	buff, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(buff)
+}
+
+// DecodeCursor parses cursor string made for paginator sort order.
+func DecodeCursor(paginator *model.Paginator) (*Cursor, error) {
+	if paginator == nil || len(paginator.Cursor) == 0 {
+		return nil, nil
+	}
+	buff, err := base64.RawURLEncoding.DecodeString(paginator.Cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	cursor := &Cursor{}
+	if err := json.Unmarshal(buff, cursor); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	// Cursor of one sort order is meaningless for another one
+	if cursor.Sort != sortOrder(paginator) {
+		return nil, ErrInvalidCursor
+	}
+	return cursor, nil
+}
+
+// CheckPaginator validates paginator fields.
+func CheckPaginator(paginator *model.Paginator) error {
+	if paginator == nil {
+		return nil
+	}
+	if paginator.Page < 0 || paginator.RequestsPerPage < 0 {
+		return ErrInvalidInputData
+	}
+	switch paginator.Sort {
+	case "", model.SortCreatedAsc, model.SortCreatedDesc:
+		return nil
+	}
+	return ErrInvalidSort
+}
+
+// NewCursor makes cursor pointing to request for paginator sort order.
+func NewCursor(paginator *model.Paginator, createdAt time.Time, seq int64) string {
+	return EncodeCursor(&Cursor{CreatedAt: createdAt, Seq: seq, Sort: sortOrder(paginator)})
+}
+
+func sortOrder(paginator *model.Paginator) model.Sort {
+	if paginator.Descending() {
+		return model.SortCreatedDesc
+	}
+	return model.SortCreatedAsc
+}
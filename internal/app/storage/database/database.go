@@ -3,12 +3,14 @@ package database
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
 	"github.com/sirupsen/logrus"
 
+	"github.com/ahamtat/itvbackend/internal/app/logging"
 	"github.com/ahamtat/itvbackend/internal/app/storage"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq" // initializing postgres driver
@@ -23,6 +25,8 @@ type Storage struct {
 	ctx    context.Context
 	logger *logrus.Logger
 	db     *sqlx.DB
+	// Context of API call logged with every entry, it is nil for unbound storage
+	call context.Context
 }
 
 // CreateDatabase initializes database connection pool.
@@ -41,20 +45,32 @@ func CreateDatabase(dsn string, poolSize int) (*sql.DB, error) {
 }
 
 // NewDatabaseStorage constructor.
-func NewDatabaseStorage(ctx context.Context, db *sql.DB) storage.Storage {
+// Nil logger is replaced with new one writing JSON entries.
+func NewDatabaseStorage(ctx context.Context, db *sql.DB, logger *logrus.Logger) storage.Storage {
+	if logger == nil {
+		logger = logging.NewLogger()
+	}
 	return &Storage{
 		ctx:    ctx,
 		db:     sqlx.NewDb(db, "postgres"),
-		logger: logrus.New(),
+		logger: logger,
 	}
 }
 
-func joinHeaders(headers map[string][]string) string {
-	temp := make([]string, 0, len(headers))
-	for k, v := range headers {
-		temp = append(temp, fmt.Sprintf("%s: %s", k, v))
+// Bind returns storage logging operations with fields of API call in context.
+// Queries are still bound to storage context, so they are not interrupted with API call.
+func (s *Storage) Bind(ctx context.Context) storage.Storage {
+	bound := *s
+	bound.call = ctx
+	return &bound
+}
+
+// log returns log entry with fields of API call storage is bound to.
+func (s *Storage) log() *logrus.Entry {
+	if s.call == nil {
+		return logrus.NewEntry(s.logger)
 	}
-	return strings.Join(temp, "; ")
+	return logging.Entry(s.logger, s.call)
 }
 
 // AddFetchData saves fetch data and return ID.
@@ -66,14 +82,20 @@ func (s *Storage) AddRequest(data *model.FetchData) (string, error) {
 	var uuid = uuid.New().String()
 	_, err := s.db.ExecContext(
 		ctx,
-		"INSERT INTO requests (uuid, method, url, fetch_headers, body) VALUES ($1, $2, $3, $4, $5) RETURNING id",
+		"INSERT INTO requests (uuid, method, url, host, fetch_headers, body, body_encoding, form, parts, options) "+
+			"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id",
 		uuid,
 		data.Method,
 		data.URL,
-		joinHeaders(data.Headers),
-		data.Body)
+		data.HostName(),
+		multiMap(data.Headers),
+		data.Body,
+		string(data.BodyEncoding),
+		multiMap(data.Form),
+		parts(data.Parts),
+		options(data.FetchOptions))
 	if err != nil {
-		s.logger.Errorf("AddRequest(): failed inserting into requests table: %s", err)
+		s.log().Errorf("AddRequest(): failed inserting into requests table: %s", err)
 		return "", err
 	}
 
@@ -86,27 +108,361 @@ func (s *Storage) AddResponse(id string, response *model.Response) error {
 	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
 	defer cancel()
 
+	var errorKind, errorMessage sql.NullString
+	if response.Error != nil {
+		errorKind = sql.NullString{String: string(response.Error.Kind), Valid: true}
+		errorMessage = sql.NullString{String: response.Error.Message, Valid: true}
+	}
+	var responseTiming *timing
+	if response.Timing != nil {
+		responseTiming = (*timing)(response.Timing)
+	}
+
 	_, err := s.db.ExecContext(
 		ctx,
-		"UPDATE requests SET status=$1, length=$2, response_headers=$3 WHERE uuid=$4",
+		"UPDATE requests SET status=$1, length=$2, response_headers=$3, response_body=$4, truncated=$5, "+
+			"error_kind=$6, error_message=$7, redirects=$8, attempts=$9, timing=$10 WHERE uuid=$11",
 		response.Status,
 		response.Length,
-		joinHeaders(response.Headers),
+		multiMap(response.Headers),
+		response.Body,
+		response.Truncated,
+		errorKind,
+		errorMessage,
+		redirects(response.Redirects),
+		attempts(response.Attempts),
+		responseTiming,
 		id)
 	if err != nil {
-		s.logger.Errorf("error updating requests table: %s", err)
+		s.log().Errorf("error updating requests table: %s", err)
 	}
 	return err
 }
 
-// GetAllRequests reads all requests from storage.
-func (s *Storage) GetAllRequests(paginator *model.Paginator) []model.Request {
-	// TODO: Unimplemented
-	return nil
+// requestRow maps requests table columns.
+type requestRow struct {
+	ID              int64          `db:"id"`
+	UUID            string         `db:"uuid"`
+	State           string         `db:"state"`
+	Error           sql.NullString `db:"error"`
+	CreatedAt       time.Time      `db:"created_at"`
+	Method          string         `db:"method"`
+	URL             string         `db:"url"`
+	FetchHeaders    multiMap       `db:"fetch_headers"`
+	Body            sql.NullString `db:"body"`
+	BodyEncoding    sql.NullString `db:"body_encoding"`
+	Form            multiMap       `db:"form"`
+	Parts           parts          `db:"parts"`
+	Options         options        `db:"options"`
+	Status          sql.NullInt64  `db:"status"`
+	ResponseHeaders multiMap       `db:"response_headers"`
+	Length          sql.NullInt64  `db:"length"`
+	ResponseBody    []byte         `db:"response_body"`
+	Truncated       bool           `db:"truncated"`
+	ErrorKind       sql.NullString `db:"error_kind"`
+	ErrorMessage    sql.NullString `db:"error_message"`
+	Redirects       redirects      `db:"redirects"`
+	Attempts        attempts       `db:"attempts"`
+	Timing          *timing        `db:"timing"`
+}
+
+// request converts table row to model.
+func (row *requestRow) request() model.Request {
+	req := model.Request{
+		ID:        row.UUID,
+		State:     model.State(row.State),
+		Error:     row.Error.String,
+		CreatedAt: row.CreatedAt,
+		Fetch: &model.FetchData{
+			Method:       row.Method,
+			URL:          row.URL,
+			Headers:      row.FetchHeaders,
+			Body:         row.Body.String,
+			BodyEncoding: model.BodyEncoding(row.BodyEncoding.String),
+			Form:         row.Form,
+			Parts:        row.Parts,
+			FetchOptions: model.FetchOptions(row.Options),
+		},
+	}
+	// Response is absent until external resource is fetched
+	if row.Status.Valid {
+		req.Response = &model.Response{
+			ID:        row.UUID,
+			Status:    int(row.Status.Int64),
+			Headers:   row.ResponseHeaders,
+			Length:    row.Length.Int64,
+			Body:      row.ResponseBody,
+			Truncated: row.Truncated,
+			Redirects: row.Redirects,
+			Attempts:  row.Attempts,
+			Timing:    (*model.Timing)(row.Timing),
+		}
+		if row.ErrorKind.Valid {
+			req.Response.Error = &model.FetchError{
+				Kind:    model.ErrorKind(row.ErrorKind.String),
+				Message: row.ErrorMessage.String,
+			}
+		}
+	}
+	return req
+}
+
+// selectRows reads rows from requests table, clauses follow FROM clause of query.
+func (s *Storage) selectRows(ctx context.Context, clauses string, args ...interface{}) ([]requestRow, error) {
+	query := "SELECT id, uuid, state, error, created_at, method, url, fetch_headers, body, body_encoding, form, " +
+		"parts, options, status, response_headers, length, response_body, truncated, error_kind, error_message, " +
+		"redirects, attempts, timing FROM requests" + clauses
+
+	rows := make([]requestRow, 0)
+	if err := s.db.SelectContext(ctx, &rows, query, args...); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// selectPage reads page of requests matching condition from requests table.
+// Pages are ordered by creation time and table ID breaking ties.
+func (s *Storage) selectPage(condition string, paginator *model.Paginator, args ...interface{}) (*model.Page, error) {
+	if err := storage.CheckPaginator(paginator); err != nil {
+		return nil, err
+	}
+	cursor, err := storage.DecodeCursor(paginator)
+	if err != nil {
+		return nil, err
+	}
+
+	// Create timed query context
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+
+	where := ""
+	if len(condition) > 0 {
+		where = " WHERE " + condition
+	}
+	page := &model.Page{}
+	if err := s.db.GetContext(ctx, &page.Total, "SELECT count(*) FROM requests"+where, args...); err != nil {
+		return nil, err
+	}
+
+	direction, compare := "ASC", ">"
+	if paginator.Descending() {
+		direction, compare = "DESC", "<"
+	}
+	if cursor != nil {
+		keyset := fmt.Sprintf("(created_at, id) %s ($%d, $%d)", compare, len(args)+1, len(args)+2)
+		if len(where) > 0 {
+			where += " AND " + keyset
+		} else {
+			where = " WHERE " + keyset
+		}
+		args = append(args, cursor.CreatedAt, cursor.Seq)
+	}
+	clauses := where + fmt.Sprintf(" ORDER BY created_at %s, id %s", direction, direction)
+
+	// Select one more row to find out whether next page exists
+	limit := 0
+	if paginator != nil && paginator.RequestsPerPage > 0 {
+		limit = paginator.RequestsPerPage
+		clauses += fmt.Sprintf(" LIMIT $%d", len(args)+1)
+		args = append(args, limit+1)
+		if cursor == nil {
+			clauses += fmt.Sprintf(" OFFSET $%d", len(args)+1)
+			args = append(args, paginator.Page*limit)
+		}
+	}
+
+	rows, err := s.selectRows(ctx, clauses, args...)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && len(rows) > limit {
+		rows = rows[:limit]
+		last := rows[limit-1]
+		page.NextCursor = storage.NewCursor(paginator, last.CreatedAt, last.ID)
+	}
+
+	page.Requests = make([]model.Request, 0, len(rows))
+	for i := range rows {
+		page.Requests = append(page.Requests, rows[i].request())
+	}
+	return page, nil
+}
+
+// UpdateState changes request processing state by ID.
+func (s *Storage) UpdateState(id string, state model.State) error {
+	// Invalid UUID could not be stored in requests table
+	if _, err := uuid.Parse(id); err != nil {
+		return storage.ErrRequestNotFound
+	}
+
+	// Create timed query context
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, "UPDATE requests SET state=$1 WHERE uuid=$2", string(state), id)
+	if err != nil {
+		s.log().Errorf("UpdateState(): failed updating requests table: %s", err)
+		return err
+	}
+	return checkAffected(res)
+}
+
+// FailRequest marks request as failed and saves failure reason by ID.
+func (s *Storage) FailRequest(id string, reason string) error {
+	// Invalid UUID could not be stored in requests table
+	if _, err := uuid.Parse(id); err != nil {
+		return storage.ErrRequestNotFound
+	}
+
+	// Create timed query context
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := s.db.ExecContext(
+		ctx,
+		"UPDATE requests SET state=$1, error=$2 WHERE uuid=$3",
+		string(model.StateFailed),
+		reason,
+		id)
+	if err != nil {
+		s.log().Errorf("FailRequest(): failed updating requests table: %s", err)
+		return err
+	}
+	return checkAffected(res)
+}
+
+// GetRequest reads request from storage by ID.
+func (s *Storage) GetRequest(id string) (*model.Request, error) {
+	// Invalid UUID could not be stored in requests table
+	if _, err := uuid.Parse(id); err != nil {
+		return nil, storage.ErrRequestNotFound
+	}
+
+	// Create timed query context
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+
+	rows, err := s.selectRows(ctx, " WHERE uuid=$1", id)
+	if err != nil {
+		s.log().Errorf("GetRequest(): failed selecting from requests table: %s", err)
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, storage.ErrRequestNotFound
+	}
+	req := rows[0].request()
+	return &req, nil
+}
+
+// FindRequests reads page of requests matching filter, nil filter matches all requests.
+func (s *Storage) FindRequests(filter *model.Filter, paginator *model.Paginator) (*model.Page, error) {
+	condition, args, err := filterCondition(filter)
+	if err != nil {
+		s.log().Errorf("FindRequests(): failed encoding filter: %s", err)
+		return nil, err
+	}
+
+	page, err := s.selectPage(condition, paginator, args...)
+	if err != nil {
+		s.log().Errorf("FindRequests(): failed selecting from requests table: %s", err)
+		return nil, err
+	}
+	return page, nil
+}
+
+// filterCondition makes WHERE condition of requests table from filter.
+// Every condition is served by index of requests table.
+func filterCondition(filter *model.Filter) (string, []interface{}, error) {
+	if filter == nil {
+		return "", nil, nil
+	}
+	conditions := make([]string, 0)
+	args := make([]interface{}, 0)
+	add := func(condition string, values ...interface{}) {
+		placeholders := make([]interface{}, 0, len(values))
+		for range values {
+			placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)+len(placeholders)+1))
+		}
+		conditions = append(conditions, fmt.Sprintf(condition, placeholders...))
+		args = append(args, values...)
+	}
+
+	if len(filter.Method) > 0 {
+		add("method = %s", filter.Method)
+	}
+	if len(filter.Host) > 0 {
+		add("host = %s", strings.ToLower(filter.Host))
+	}
+	if len(filter.URLPrefix) > 0 {
+		add("url LIKE %s", escapeLike(filter.URLPrefix)+"%")
+	}
+	if len(filter.Search) > 0 {
+		add("url ILIKE %s", "%"+escapeLike(filter.Search)+"%")
+	}
+	if filter.Status > 0 {
+		add("status = %s", filter.Status)
+	}
+	if filter.StatusClass > 0 {
+		add("status >= %s AND status < %s", filter.StatusClass*100, (filter.StatusClass+1)*100)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		add("created_at >= %s", filter.CreatedFrom)
+	}
+	if !filter.CreatedTo.IsZero() {
+		add("created_at < %s", filter.CreatedTo)
+	}
+	if len(filter.ErrorKind) > 0 {
+		add("error_kind = %s", string(filter.ErrorKind))
+	}
+	if len(filter.Header) > 0 {
+		// Headers are saved as received, names are searched in lower case index
+		name := strings.ToLower(filter.Header)
+		if len(filter.HeaderValue) > 0 {
+			// Use containment operator to benefit from GIN indexes
+			buff, err := json.Marshal(map[string][]string{name: {filter.HeaderValue}})
+			if err != nil {
+				return "", nil, err
+			}
+			add("(lower_header_names(fetch_headers) @> %[1]s OR lower_header_names(response_headers) @> %[1]s)", string(buff))
+		} else {
+			add("(lower_header_names(fetch_headers) ? %[1]s OR lower_header_names(response_headers) ? %[1]s)", name)
+		}
+	}
+	return strings.Join(conditions, " AND "), args, nil
+}
+
+// escapeLike escapes wildcards of LIKE pattern.
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value)
 }
 
 // DeleteRequest removes request from storage by ID.
 func (s *Storage) DeleteRequest(id string) error {
-	// TODO: Unimplemented
+	// Invalid UUID could not be stored in requests table
+	if _, err := uuid.Parse(id); err != nil {
+		return storage.ErrRequestNotFound
+	}
+
+	// Create timed query context
+	ctx, cancel := context.WithTimeout(s.ctx, 5*time.Second)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, "DELETE FROM requests WHERE uuid=$1", id)
+	if err != nil {
+		s.log().Errorf("DeleteRequest(): failed deleting from requests table: %s", err)
+		return err
+	}
+	return checkAffected(res)
+}
+
+// checkAffected returns ErrRequestNotFound if no rows were affected by query.
+func checkAffected(res sql.Result) error {
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrRequestNotFound
+	}
 	return nil
 }
@@ -2,20 +2,56 @@ package database_test
 
 import (
 	"context"
+	"database/sql/driver"
+	"errors"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/ahamtat/itvbackend/internal/app/logging"
 	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/ahamtat/itvbackend/internal/app/storage"
 
 	"github.com/ahamtat/itvbackend/internal/app/storage/database"
 
 	"github.com/DATA-DOG/go-sqlmock"
 )
 
+var columns = []string{
+	"id", "uuid", "state", "error", "created_at", "method", "url", "fetch_headers", "body", "body_encoding", "form", "parts", "options",
+	"status", "response_headers", "length", "response_body", "truncated", "error_kind", "error_message", "redirects",
+	"attempts", "timing",
+}
+
+// createdAt is default creation time of requests table rows.
+var createdAt = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+// row makes requests table row from column values, absent columns are NULL.
+func row(values map[string]driver.Value) []driver.Value {
+	result := make([]driver.Value, len(columns))
+	for i, column := range columns {
+		value, ok := values[column]
+		if !ok {
+			// Columns are not nullable
+			switch column {
+			case "id":
+				value = int64(1)
+			case "created_at":
+				value = createdAt
+			case "truncated":
+				value = false
+			}
+		}
+		result[i] = value
+	}
+	return result
+}
+
 func TestDatabaseStorage_AddRequest(t *testing.T) {
 	db, mock, err := sqlmock.New()
 	if err != nil {
@@ -24,7 +60,7 @@ func TestDatabaseStorage_AddRequest(t *testing.T) {
 	defer db.Close()
 
 	// Create database storage
-	s := database.NewDatabaseStorage(context.Background(), db)
+	s := database.NewDatabaseStorage(context.Background(), db, nil)
 
 	// Make database mocks
 	mock.ExpectExec("INSERT INTO requests").
@@ -32,16 +68,24 @@ func TestDatabaseStorage_AddRequest(t *testing.T) {
 			sqlmock.AnyArg(),
 			"GET",
 			"http://google.com",
+			"google.com",
+			`{"Accept":["text/html","application/json"]}`,
 			"",
-			"").
+			"",
+			nil,
+			nil,
+			`{"captureBody":true}`).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
 	// Execute method
 	_, err = s.AddRequest(&model.FetchData{
 		Method:  "GET",
 		URL:     "http://google.com",
-		Headers: nil,
+		Headers: map[string][]string{"Accept": {"text/html", "application/json"}},
 		Body:    "",
+		FetchOptions: model.FetchOptions{
+			CaptureBody: true,
+		},
 	})
 	require.Nil(t, err)
 
@@ -57,7 +101,7 @@ func TestStorage_AddResponse(t *testing.T) {
 	defer db.Close()
 
 	// Create database storage
-	s := database.NewDatabaseStorage(context.Background(), db)
+	s := database.NewDatabaseStorage(context.Background(), db, nil)
 
 	// Make database mocks
 	mock.ExpectExec(
@@ -65,7 +109,14 @@ func TestStorage_AddResponse(t *testing.T) {
 		WithArgs(
 			http.StatusOK,
 			0,
-			"",
+			nil,
+			[]byte("<html>"),
+			false,
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
 			sqlmock.AnyArg()).
 		WillReturnResult(sqlmock.NewResult(1, 1))
 
@@ -77,9 +128,469 @@ func TestStorage_AddResponse(t *testing.T) {
 			Status:  200,
 			Headers: nil,
 			Length:  0,
+			Body:    []byte("<html>"),
+		})
+	require.Nil(t, err)
+
+	// Save response without HTTP status
+	mock.ExpectExec(
+		"UPDATE requests").
+		WithArgs(
+			0,
+			0,
+			nil,
+			[]byte(nil),
+			false,
+			"timeout",
+			"i/o timeout",
+			nil,
+			`[{"status":0,"error":{"kind":"timeout","message":"i/o timeout"},"durationMs":5000}]`,
+			`{"dnsMs":1.5,"connectMs":0,"tlsMs":0,"firstByteMs":0,"transferMs":0,"totalMs":5000,"reused":false}`,
+			sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	err = s.AddResponse(
+		uuid.New().String(),
+		&model.Response{
+			Error: &model.FetchError{
+				Kind:    model.ErrorKindTimeout,
+				Message: "i/o timeout",
+			},
+			Attempts: []model.Attempt{
+				{
+					Error: &model.FetchError{
+						Kind:    model.ErrorKindTimeout,
+						Message: "i/o timeout",
+					},
+					DurationMS: 5000,
+				},
+			},
+			Timing: &model.Timing{
+				DNSMS:   1.5,
+				TotalMS: 5000,
+			},
 		})
 	require.Nil(t, err)
 
 	// Make sure that all expectations were met
 	require.Nil(t, mock.ExpectationsWereMet())
 }
+
+func TestStorage_FindAllRequests(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	// Create database storage
+	s := database.NewDatabaseStorage(context.Background(), db, nil)
+
+	// Make database mocks
+	ID := uuid.New().String()
+	mock.ExpectQuery(`SELECT count\(\*\) FROM requests$`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+	mock.ExpectQuery(`SELECT (.+) FROM requests ORDER BY created_at ASC, id ASC LIMIT \$1 OFFSET \$2`).
+		WithArgs(3, 4).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(row(map[string]driver.Value{
+				"id":               int64(5),
+				"uuid":             ID,
+				"state":            "succeeded",
+				"method":           "GET",
+				"url":              "http://google.com",
+				"fetch_headers":    []byte(`{"Accept":["text/html","application/json"]}`),
+				"body":             "",
+				"options":          []byte(`{"captureBody":true,"timeoutMs":1000}`),
+				"status":           http.StatusOK,
+				"response_headers": []byte(`{"Set-Cookie":["a=1","b=2"]}`),
+				"length":           100,
+				"response_body":    []byte("<html>"),
+				"truncated":        true,
+				"redirects":        []byte(`[{"url":"http://google.com","status":301,"location":"http://www.google.com/"}]`),
+				"attempts":         []byte(`[{"status":503,"durationMs":10},{"status":200,"durationMs":12}]`),
+				"timing":           []byte(`{"dnsMs":0.5,"firstByteMs":8,"totalMs":12,"remoteAddr":"1.2.3.4:80","reused":true}`),
+			})...).
+			AddRow(row(map[string]driver.Value{
+				"id":            int64(6),
+				"uuid":          uuid.New().String(),
+				"state":         "queued",
+				"method":        "POST",
+				"url":           "http://google.com",
+				"body":          "data",
+				"body_encoding": "form",
+				"form":          []byte(`{"a":["1"]}`),
+			})...).
+			AddRow(row(map[string]driver.Value{
+				"id":     int64(7),
+				"uuid":   uuid.New().String(),
+				"state":  "queued",
+				"method": "GET",
+				"url":    "http://google.com",
+			})...))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM requests$`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(7))
+	mock.ExpectQuery(`SELECT (.+) FROM requests WHERE \(created_at, id\) > \(\$1, \$2\) `+
+		`ORDER BY created_at ASC, id ASC LIMIT \$3$`).
+		WithArgs(createdAt, 6, 3).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	// Execute method
+	page, err := s.FindRequests(nil, &model.Paginator{
+		Page:            2,
+		RequestsPerPage: 2,
+	})
+	require.Nil(t, err)
+	require.Equal(t, 7, page.Total)
+	require.NotEmpty(t, page.NextCursor)
+	requests := page.Requests
+	require.Equal(t, 2, len(requests))
+	require.Equal(t, createdAt, requests[0].CreatedAt)
+	require.Equal(t, map[string][]string{"Accept": {"text/html", "application/json"}}, requests[0].Fetch.Headers)
+	require.Equal(t, &model.Response{
+		ID:        ID,
+		Status:    http.StatusOK,
+		Headers:   map[string][]string{"Set-Cookie": {"a=1", "b=2"}},
+		Length:    100,
+		Body:      []byte("<html>"),
+		Truncated: true,
+		Redirects: []model.Redirect{
+			{URL: "http://google.com", Status: http.StatusMovedPermanently, Location: "http://www.google.com/"},
+		},
+		Attempts: []model.Attempt{
+			{Status: http.StatusServiceUnavailable, DurationMS: 10},
+			{Status: http.StatusOK, DurationMS: 12},
+		},
+		Timing: &model.Timing{
+			DNSMS:       0.5,
+			FirstByteMS: 8,
+			TotalMS:     12,
+			RemoteAddr:  "1.2.3.4:80",
+			Reused:      true,
+		},
+	}, requests[0].Response)
+	require.Equal(t, model.FetchOptions{CaptureBody: true, TimeoutMS: 1000}, requests[0].Fetch.FetchOptions)
+	require.Equal(t, "data", requests[1].Fetch.Body)
+	require.Equal(t, model.BodyEncodingForm, requests[1].Fetch.BodyEncoding)
+	require.Equal(t, map[string][]string{"a": {"1"}}, requests[1].Fetch.Form)
+	require.Nil(t, requests[1].Response)
+
+	// Next page starts after cursor
+	page, err = s.FindRequests(nil, &model.Paginator{
+		Page:            2,
+		RequestsPerPage: 2,
+		Cursor:          page.NextCursor,
+	})
+	require.Nil(t, err)
+	require.Empty(t, page.Requests)
+	require.Empty(t, page.NextCursor)
+
+	// Invalid paginator is rejected without querying database
+	_, err = s.FindRequests(nil, &model.Paginator{Sort: "url"})
+	require.Equal(t, storage.ErrInvalidSort, err)
+	_, err = s.FindRequests(nil, &model.Paginator{Cursor: "garbage"})
+	require.Equal(t, storage.ErrInvalidCursor, err)
+
+	// Make sure that all expectations were met
+	require.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestStorage_FindRequestsByHeader(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	// Create database storage
+	s := database.NewDatabaseStorage(context.Background(), db, nil)
+
+	// Make database mocks
+	mock.ExpectQuery(`SELECT count\(\*\) FROM requests WHERE \(lower_header_names\(fetch_headers\) \? \$1`).
+		WithArgs("accept").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT (.+) FROM requests WHERE \(lower_header_names\(fetch_headers\) \? \$1`).
+		WithArgs("accept").
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(row(map[string]driver.Value{
+				"uuid":          uuid.New().String(),
+				"state":         "queued",
+				"method":        "GET",
+				"url":           "http://google.com",
+				"fetch_headers": []byte(`{"Accept":["text/html"]}`),
+			})...))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM requests WHERE \(lower_header_names\(fetch_headers\) @> \$1`).
+		WithArgs(`{"accept":["application/json"]}`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`SELECT (.+) FROM requests WHERE \(lower_header_names\(fetch_headers\) @> \$1 (.+) ORDER BY created_at DESC, id DESC`).
+		WithArgs(`{"accept":["application/json"]}`, 11, 0).
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	// Execute method
+	page, err := s.FindRequests(&model.Filter{Header: "Accept", HeaderValue: ""}, nil)
+	require.Nil(t, err)
+	require.Equal(t, 1, page.Total)
+	require.Equal(t, 1, len(page.Requests))
+	page, err = s.FindRequests(&model.Filter{Header: "Accept", HeaderValue: "application/json"}, &model.Paginator{
+		Page:            0,
+		RequestsPerPage: 10,
+		Sort:            model.SortCreatedDesc,
+	})
+	require.Nil(t, err)
+	require.Empty(t, page.Requests)
+
+	// Make sure that all expectations were met
+	require.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestStorage_HeaderCase(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	// Create database storage
+	s := database.NewDatabaseStorage(context.Background(), db, nil)
+
+	// Make database mocks, headers are saved as received and searched in lower case
+	mock.ExpectExec("INSERT INTO requests").
+		WithArgs(sqlmock.AnyArg(), "GET", "http://google.com", "google.com",
+			`{"content-type":["text/html"]}`, "", "", nil, nil, `{}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM requests WHERE \(lower_header_names\(fetch_headers\) @> \$1`).
+		WithArgs(`{"content-type":["text/html"]}`).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`SELECT (.+) FROM requests WHERE \(lower_header_names\(fetch_headers\) @> \$1`).
+		WithArgs(`{"content-type":["text/html"]}`).
+		WillReturnRows(sqlmock.NewRows(columns))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM requests WHERE \(lower_header_names\(fetch_headers\) \? \$1`).
+		WithArgs("x-request-id").
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectQuery(`SELECT (.+) FROM requests WHERE \(lower_header_names\(fetch_headers\) \? \$1`).
+		WithArgs("x-request-id").
+		WillReturnRows(sqlmock.NewRows(columns))
+
+	// Execute method
+	_, err = s.AddRequest(&model.FetchData{
+		Method:  "GET",
+		URL:     "http://google.com",
+		Headers: map[string][]string{"content-type": {"text/html"}},
+	})
+	require.Nil(t, err)
+	_, err = s.FindRequests(&model.Filter{Header: "content-TYPE", HeaderValue: "text/html"}, nil)
+	require.Nil(t, err)
+	_, err = s.FindRequests(&model.Filter{Header: "X-Request-ID"}, nil)
+	require.Nil(t, err)
+
+	// Make sure that all expectations were met
+	require.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestStorage_FindRequests(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	// Create database storage
+	s := database.NewDatabaseStorage(context.Background(), db, nil)
+
+	// Make database mocks
+	from := time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)
+	to := from.Add(24 * time.Hour)
+	condition := `WHERE method = \$1 AND host = \$2 AND url LIKE \$3 AND url ILIKE \$4 AND ` +
+		`status >= \$5 AND status < \$6 AND created_at >= \$7 AND created_at < \$8 AND error_kind = \$9 AND ` +
+		`\(lower_header_names\(fetch_headers\) \? \$10 OR lower_header_names\(response_headers\) \? \$10\)`
+	args := []driver.Value{"GET", "google.com", `http://google.com/a\_b%`, `%100\%%`, 500, 600, from, to, "timeout", "accept"}
+	mock.ExpectQuery(`SELECT count\(\*\) FROM requests ` + condition + `$`).
+		WithArgs(args...).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+	mock.ExpectQuery(`SELECT (.+) FROM requests ` + condition + ` ORDER BY created_at ASC, id ASC$`).
+		WithArgs(args...).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(row(map[string]driver.Value{
+				"uuid":       uuid.New().String(),
+				"state":      "succeeded",
+				"method":     "GET",
+				"url":        "http://google.com/a_b?q=100%",
+				"status":     http.StatusServiceUnavailable,
+				"error_kind": "timeout",
+			})...))
+	mock.ExpectQuery(`SELECT count\(\*\) FROM requests WHERE status = \$1$`).
+		WithArgs(404).
+		WillReturnError(errors.New("connection reset"))
+
+	// Execute method
+	page, err := s.FindRequests(&model.Filter{
+		Method:      "GET",
+		Host:        "Google.com",
+		URLPrefix:   "http://google.com/a_b",
+		Search:      "100%",
+		StatusClass: 5,
+		CreatedFrom: from,
+		CreatedTo:   to,
+		ErrorKind:   model.ErrorKindTimeout,
+		Header:      "Accept",
+	}, nil)
+	require.Nil(t, err)
+	require.Equal(t, 1, page.Total)
+	require.Equal(t, 1, len(page.Requests))
+
+	// Database errors are returned
+	_, err = s.FindRequests(&model.Filter{Status: http.StatusNotFound}, nil)
+	require.NotNil(t, err)
+
+	// Make sure that all expectations were met
+	require.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestStorage_FailRequest(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	// Create database storage
+	s := database.NewDatabaseStorage(context.Background(), db, nil)
+
+	// Make database mocks
+	ID := uuid.New().String()
+	mock.ExpectExec("UPDATE requests SET state").
+		WithArgs("failed", "wrong HTTP method", ID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	// Execute method
+	require.Nil(t, s.FailRequest(ID, "wrong HTTP method"))
+	require.Equal(t, storage.ErrRequestNotFound, s.FailRequest("invalid", "wrong HTTP method"))
+
+	// Make sure that all expectations were met
+	require.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestStorage_Bind(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	// Storage bound to API call logs its correlation fields
+	logger, hook := logtest.NewNullLogger()
+	ctx := logging.WithCall(context.Background(), "correlation-1")
+	ID := uuid.New().String()
+	logging.SetRequestUUID(ctx, ID)
+	s := storage.Bind(ctx, database.NewDatabaseStorage(context.Background(), db, logger))
+
+	mock.ExpectExec("UPDATE requests SET state").
+		WithArgs("failed", "wrong HTTP method", ID).
+		WillReturnError(errors.New("connection reset"))
+	require.NotNil(t, s.FailRequest(ID, "wrong HTTP method"))
+
+	entry := hook.LastEntry()
+	require.NotNil(t, entry)
+	require.Equal(t, "correlation-1", entry.Data["request_id"])
+	require.Equal(t, ID, entry.Data["request_uuid"])
+
+	// Make sure that all expectations were met
+	require.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestStorage_GetRequest(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	// Create database storage
+	s := database.NewDatabaseStorage(context.Background(), db, nil)
+
+	// Make database mocks
+	existingID, missingID := uuid.New().String(), uuid.New().String()
+	mock.ExpectExec("UPDATE requests SET state").
+		WithArgs("running", existingID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`SELECT (.+) FROM requests WHERE uuid=\$1`).
+		WithArgs(existingID).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(row(map[string]driver.Value{
+				"uuid":   existingID,
+				"state":  "running",
+				"method": "GET",
+				"url":    "http://google.com",
+				"parts":  []byte(`[{"name":"file","body":"AA==","base64":true}]`),
+			})...))
+	mock.ExpectQuery(`SELECT (.+) FROM requests WHERE uuid=\$1`).
+		WithArgs(missingID).
+		WillReturnRows(sqlmock.NewRows(columns))
+	failedID := uuid.New().String()
+	mock.ExpectQuery(`SELECT (.+) FROM requests WHERE uuid=\$1`).
+		WithArgs(failedID).
+		WillReturnRows(sqlmock.NewRows(columns).
+			AddRow(row(map[string]driver.Value{
+				"uuid":          failedID,
+				"state":         "failed",
+				"error":         "dns: no such host",
+				"method":        "GET",
+				"url":           "http://google.com",
+				"status":        0,
+				"length":        0,
+				"error_kind":    "dns",
+				"error_message": "no such host",
+			})...))
+
+	// Execute methods
+	require.Nil(t, s.UpdateState(existingID, model.StateRunning))
+	req, err := s.GetRequest(existingID)
+	require.Nil(t, err)
+	require.Equal(t, existingID, req.ID)
+	require.Equal(t, model.StateRunning, req.State)
+	require.Equal(t, []model.Part{{Name: "file", Body: "AA==", Base64: true}}, req.Fetch.Parts)
+	require.Nil(t, req.Response)
+	_, err = s.GetRequest(missingID)
+	require.Equal(t, storage.ErrRequestNotFound, err)
+	req, err = s.GetRequest(failedID)
+	require.Nil(t, err)
+	require.Equal(t, model.StateFailed, req.State)
+	require.Equal(t, "dns: no such host", req.Error)
+	require.Equal(t, 0, req.Response.Status)
+	require.Equal(t, &model.FetchError{
+		Kind:    model.ErrorKindDNS,
+		Message: "no such host",
+	}, req.Response.Error)
+	_, err = s.GetRequest("invalid")
+	require.Equal(t, storage.ErrRequestNotFound, err)
+
+	// Make sure that all expectations were met
+	require.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestStorage_DeleteRequest(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+
+	// Create database storage
+	s := database.NewDatabaseStorage(context.Background(), db, nil)
+
+	// Make database mocks
+	existingID, missingID := uuid.New().String(), uuid.New().String()
+	mock.ExpectExec("DELETE FROM requests").
+		WithArgs(existingID).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM requests").
+		WithArgs(missingID).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	// Execute method
+	require.Nil(t, s.DeleteRequest(existingID))
+	require.Equal(t, storage.ErrRequestNotFound, s.DeleteRequest(missingID))
+	require.Equal(t, storage.ErrRequestNotFound, s.DeleteRequest("invalid"))
+
+	// Make sure that all expectations were met
+	require.Nil(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,123 @@
+package database
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/pkg/errors"
+)
+
+// multiMap stores HTTP headers or form values in JSONB column.
+type multiMap map[string][]string
+
+// Value implements driver.Valuer interface.
+func (m multiMap) Value() (driver.Value, error) {
+	if len(m) == 0 {
+		return nil, nil
+	}
+	return marshalJSON(m)
+}
+
+// Scan implements sql.Scanner interface.
+func (m *multiMap) Scan(src interface{}) error {
+	return unmarshalJSON(src, m)
+}
+
+// parts stores multipart body in JSONB column.
+type parts []model.Part
+
+// Value implements driver.Valuer interface.
+func (p parts) Value() (driver.Value, error) {
+	if len(p) == 0 {
+		return nil, nil
+	}
+	return marshalJSON(p)
+}
+
+// Scan implements sql.Scanner interface.
+func (p *parts) Scan(src interface{}) error {
+	return unmarshalJSON(src, p)
+}
+
+// options stores fetch options in JSONB column.
+type options model.FetchOptions
+
+// Value implements driver.Valuer interface.
+func (o options) Value() (driver.Value, error) {
+	return marshalJSON(o)
+}
+
+// Scan implements sql.Scanner interface.
+func (o *options) Scan(src interface{}) error {
+	return unmarshalJSON(src, o)
+}
+
+// redirects stores redirect chain in JSONB column.
+type redirects []model.Redirect
+
+// Value implements driver.Valuer interface.
+func (r redirects) Value() (driver.Value, error) {
+	if len(r) == 0 {
+		return nil, nil
+	}
+	return marshalJSON(r)
+}
+
+// Scan implements sql.Scanner interface.
+func (r *redirects) Scan(src interface{}) error {
+	return unmarshalJSON(src, r)
+}
+
+// attempts stores fetch attempts in JSONB column.
+type attempts []model.Attempt
+
+// Value implements driver.Valuer interface.
+func (a attempts) Value() (driver.Value, error) {
+	if len(a) == 0 {
+		return nil, nil
+	}
+	return marshalJSON(a)
+}
+
+// Scan implements sql.Scanner interface.
+func (a *attempts) Scan(src interface{}) error {
+	return unmarshalJSON(src, a)
+}
+
+// timing stores timing breakdown in JSONB column.
+type timing model.Timing
+
+// Value implements driver.Valuer interface.
+func (t timing) Value() (driver.Value, error) {
+	return marshalJSON(t)
+}
+
+// Scan implements sql.Scanner interface.
+func (t *timing) Scan(src interface{}) error {
+	return unmarshalJSON(src, t)
+}
+
+func marshalJSON(v interface{}) (driver.Value, error) {
+	buff, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return string(buff), nil
+}
+
+// unmarshalJSON decodes JSONB column value, NULL leaves dest untouched.
+func unmarshalJSON(src, dest interface{}) error {
+	var buff []byte
+	switch value := src.(type) {
+	case nil:
+		return nil
+	case []byte:
+		buff = value
+	case string:
+		buff = []byte(value)
+	default:
+		return errors.Errorf("unsupported JSON column type %T", src)
+	}
+	return json.Unmarshal(buff, dest)
+}
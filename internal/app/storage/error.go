@@ -5,4 +5,6 @@ import "github.com/pkg/errors"
 var (
 	ErrInvalidInputData = errors.New("invalid input data")
 	ErrRequestNotFound  = errors.New("request not found")
+	ErrInvalidCursor    = errors.New("invalid pagination cursor")
+	ErrInvalidSort      = errors.New("invalid sort order")
 )
@@ -1,7 +1,9 @@
 package memory
 
 import (
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/ahamtat/itvbackend/internal/app/storage"
 
@@ -13,6 +15,11 @@ import (
 type MemoryStorage struct {
 	mx      sync.Mutex
 	storage map[string]*model.Request
+
+	// Request IDs in creation order with their sequence numbers
+	order []string
+	seq   map[string]int64
+	last  int64
 }
 
 // NewMemoryStorage constructor.
@@ -20,6 +27,7 @@ func NewMemoryStorage() storage.Storage {
 	return &MemoryStorage{
 		mx:      sync.Mutex{},
 		storage: make(map[string]*model.Request),
+		seq:     make(map[string]int64),
 	}
 }
 
@@ -35,9 +43,15 @@ func (s *MemoryStorage) AddRequest(data *model.FetchData) (string, error) {
 	// Create new request in memory
 	ID := uuid.New().String()
 	s.storage[ID] = &model.Request{
-		Fetch:    data,
-		Response: nil,
+		ID:        ID,
+		State:     model.StateQueued,
+		CreatedAt: time.Now(),
+		Fetch:     data,
+		Response:  nil,
 	}
+	s.last++
+	s.order = append(s.order, ID)
+	s.seq[ID] = s.last
 	return ID, nil
 }
 
@@ -60,33 +74,191 @@ func (s *MemoryStorage) AddResponse(id string, response *model.Response) error {
 	return nil
 }
 
-// GetAllRequests reads all requests from storage.
-func (s *MemoryStorage) GetAllRequests(paginator *model.Paginator) []model.Request {
-	capacity := len(s.storage)
+// UpdateState changes request processing state by ID.
+func (s *MemoryStorage) UpdateState(id string, state model.State) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	req, ok := s.storage[id]
+	if !ok {
+		return storage.ErrRequestNotFound
+	}
+	req.State = state
+	return nil
+}
+
+// FailRequest marks request as failed and saves failure reason by ID.
+func (s *MemoryStorage) FailRequest(id string, reason string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	req, ok := s.storage[id]
+	if !ok {
+		return storage.ErrRequestNotFound
+	}
+	req.State = model.StateFailed
+	req.Error = reason
+	return nil
+}
+
+// GetRequest reads request from storage by ID.
+func (s *MemoryStorage) GetRequest(id string) (*model.Request, error) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	req, ok := s.storage[id]
+	if !ok {
+		return nil, storage.ErrRequestNotFound
+	}
+
+	// Copy request for reliability
+	result := *req
+	return &result, nil
+}
+
+// FindRequests reads page of requests matching filter, nil filter matches all requests.
+func (s *MemoryStorage) FindRequests(filter *model.Filter, paginator *model.Paginator) (*model.Page, error) {
+	if filter == nil {
+		return s.getRequests(nil, paginator)
+	}
+	return s.getRequests(func(req *model.Request) bool {
+		return matches(req, filter)
+	}, paginator)
+}
+
+// matches reports whether request satisfies every field of filter.
+func matches(req *model.Request, filter *model.Filter) bool {
+	fetch := req.Fetch
+	if fetch == nil {
+		fetch = &model.FetchData{}
+	}
+	if len(filter.Method) > 0 && fetch.Method != filter.Method {
+		return false
+	}
+	if len(filter.Host) > 0 && !strings.EqualFold(fetch.HostName(), filter.Host) {
+		return false
+	}
+	if !strings.HasPrefix(fetch.URL, filter.URLPrefix) {
+		return false
+	}
+	if len(filter.Search) > 0 && !strings.Contains(strings.ToLower(fetch.URL), strings.ToLower(filter.Search)) {
+		return false
+	}
+	if !filter.CreatedFrom.IsZero() && req.CreatedAt.Before(filter.CreatedFrom) {
+		return false
+	}
+	if !filter.CreatedTo.IsZero() && !req.CreatedAt.Before(filter.CreatedTo) {
+		return false
+	}
+
+	// Response fields match only fetched requests
+	if filter.Status > 0 || filter.StatusClass > 0 || len(filter.ErrorKind) > 0 {
+		if req.Response == nil {
+			return false
+		}
+		if filter.Status > 0 && req.Response.Status != filter.Status {
+			return false
+		}
+		if filter.StatusClass > 0 && req.Response.Status/100 != filter.StatusClass {
+			return false
+		}
+		if len(filter.ErrorKind) > 0 && (req.Response.Error == nil || req.Response.Error.Kind != filter.ErrorKind) {
+			return false
+		}
+	}
+
+	if len(filter.Header) > 0 {
+		if hasHeader(fetch.Headers, filter.Header, filter.HeaderValue) {
+			return true
+		}
+		return req.Response != nil && hasHeader(req.Response.Headers, filter.Header, filter.HeaderValue)
+	}
+	return true
+}
+
+// hasHeader reports whether headers have header with given name in any case
+// and value. Empty value matches any header value.
+func hasHeader(headers map[string][]string, name, value string) bool {
+	for key, values := range headers {
+		if !strings.EqualFold(key, name) {
+			continue
+		}
+		if len(value) == 0 {
+			return true
+		}
+		for _, v := range values {
+			if v == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getRequests reads page of requests satisfying match function from storage.
+// Nil match function selects all requests.
+func (s *MemoryStorage) getRequests(match func(req *model.Request) bool, paginator *model.Paginator) (*model.Page, error) {
+	if err := storage.CheckPaginator(paginator); err != nil {
+		return nil, err
+	}
+	cursor, err := storage.DecodeCursor(paginator)
+	if err != nil {
+		return nil, err
+	}
+	limit, offset := 0, 0
 	if paginator != nil {
-		capacity = paginator.RequestsPerPage
+		limit = paginator.RequestsPerPage
+		if cursor == nil {
+			offset = paginator.Page * limit
+		}
 	}
-	result := make([]model.Request, 0, capacity)
 
 	s.mx.Lock()
 	defer s.mx.Unlock()
 
-	// Copy requests for reliability
-	index := -1
-	for _, value := range s.storage {
-		index++
+	page := &model.Page{Requests: make([]model.Request, 0, limit)}
+	for i := range s.order {
+		ID := s.order[i]
+		if paginator.Descending() {
+			ID = s.order[len(s.order)-1-i]
+		}
+		req := s.storage[ID]
+		if match != nil && !match(req) {
+			continue
+		}
+		page.Total++
+
+		// Skip requests before cursor or from previous pages
+		if cursor != nil && !after(paginator, s.seq[ID], cursor) {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
 
-		// Skip request from undesirable page
-		if paginator != nil && index/paginator.RequestsPerPage != paginator.Page {
+		if limit > 0 && len(page.Requests) == limit {
+			// Next page exists, so point cursor to last request of current one
+			if len(page.NextCursor) == 0 {
+				last := &page.Requests[len(page.Requests)-1]
+				page.NextCursor = storage.NewCursor(paginator, last.CreatedAt, s.seq[last.ID])
+			}
 			continue
 		}
 
-		result = append(result, model.Request{
-			Fetch:    value.Fetch,
-			Response: value.Response,
-		})
+		// Copy request for reliability
+		page.Requests = append(page.Requests, *req)
 	}
-	return result
+	return page, nil
+}
+
+// after reports whether request goes after cursor in paginator sort order.
+// Sequence follows creation order even if wall clock goes back.
+func after(paginator *model.Paginator, seq int64, cursor *storage.Cursor) bool {
+	if paginator.Descending() {
+		return seq < cursor.Seq
+	}
+	return seq > cursor.Seq
 }
 
 // DeleteRequest removes request from storage by ID.
@@ -100,5 +272,12 @@ func (s *MemoryStorage) DeleteRequest(id string) error {
 		return storage.ErrRequestNotFound
 	}
 	delete(s.storage, id)
+	delete(s.seq, id)
+	for i := range s.order {
+		if s.order[i] == id {
+			s.order = append(s.order[:i], s.order[i+1:]...)
+			break
+		}
+	}
 	return nil
 }
@@ -93,7 +93,45 @@ func TestMemoryStorage_AddResponse(t *testing.T) {
 	require.NotNil(t, err)
 }
 
-func TestMemoryStorage_GetAllRequests(t *testing.T) {
+func TestMemoryStorage_UpdateState(t *testing.T) {
+	s := memory.NewMemoryStorage()
+	require.NotNil(t, s)
+
+	ID, err := s.AddRequest(&model.FetchData{
+		Method:  "GET",
+		URL:     "http://google.com",
+		Headers: nil,
+		Body:    "",
+	})
+	require.Nil(t, err)
+
+	// New request is queued
+	req, err := s.GetRequest(ID)
+	require.Nil(t, err)
+	require.Equal(t, ID, req.ID)
+	require.Equal(t, model.StateQueued, req.State)
+
+	// Change request state
+	require.Nil(t, s.UpdateState(ID, model.StateRunning))
+	req, err = s.GetRequest(ID)
+	require.Nil(t, err)
+	require.Equal(t, model.StateRunning, req.State)
+
+	// Fail request with reason
+	require.Nil(t, s.FailRequest(ID, "request timeout"))
+	req, err = s.GetRequest(ID)
+	require.Nil(t, err)
+	require.Equal(t, model.StateFailed, req.State)
+	require.Equal(t, "request timeout", req.Error)
+
+	// Non-existing request
+	require.Equal(t, storage.ErrRequestNotFound, s.UpdateState(uuid.New().String(), model.StateFailed))
+	require.Equal(t, storage.ErrRequestNotFound, s.FailRequest(uuid.New().String(), "request timeout"))
+	_, err = s.GetRequest(uuid.New().String())
+	require.Equal(t, storage.ErrRequestNotFound, err)
+}
+
+func TestMemoryStorage_FindAllRequests(t *testing.T) {
 	s := memory.NewMemoryStorage()
 	require.NotNil(t, s)
 
@@ -114,11 +152,19 @@ func TestMemoryStorage_GetAllRequests(t *testing.T) {
 	}
 	require.Equal(t, len(generatedID), totalRequests)
 
-	// Get ALL requests list
-	requests := s.GetAllRequests(nil)
-	require.Equal(t, totalRequests, len(requests))
-	for _, req := range requests {
+	// Get ALL requests list in creation order
+	page, err := s.FindRequests(nil, nil)
+	require.Nil(t, err)
+	require.Equal(t, totalRequests, len(page.Requests))
+	require.Equal(t, totalRequests, page.Total)
+	require.Empty(t, page.NextCursor)
+	for i, req := range page.Requests {
+		assert.Equal(t, generatedID[i], req.ID)
+		assert.False(t, req.CreatedAt.IsZero())
 		assert.Equal(t, &model.Request{
+			ID:        req.ID,
+			State:     model.StateQueued,
+			CreatedAt: req.CreatedAt,
 			Fetch: &model.FetchData{
 				Method:  "GET",
 				URL:     "http://google.com",
@@ -130,11 +176,230 @@ func TestMemoryStorage_GetAllRequests(t *testing.T) {
 	}
 
 	// Get requests for one page
-	requests = s.GetAllRequests(&model.Paginator{
+	page, err = s.FindRequests(nil, &model.Paginator{
 		Page:            2,
 		RequestsPerPage: 3,
 	})
-	require.Equal(t, 3, len(requests))
+	require.Nil(t, err)
+	require.Equal(t, 3, len(page.Requests))
+	require.Equal(t, generatedID[6], page.Requests[0].ID)
+	require.Equal(t, totalRequests, page.Total)
+	require.NotEmpty(t, page.NextCursor)
+}
+
+func TestMemoryStorage_FindAllRequestsCursor(t *testing.T) {
+	for _, sort := range []model.Sort{model.SortCreatedAsc, model.SortCreatedDesc} {
+		s := memory.NewMemoryStorage()
+		expected := make([]string, 0, 5)
+		for i := 0; i < 5; i++ {
+			ID, err := s.AddRequest(&model.FetchData{Method: "GET", URL: "http://google.com"})
+			require.Nil(t, err)
+			if sort == model.SortCreatedDesc {
+				expected = append([]string{ID}, expected...)
+			} else {
+				expected = append(expected, ID)
+			}
+		}
+
+		// Walk pages by cursor
+		paginator := &model.Paginator{RequestsPerPage: 2, Sort: sort}
+		listed := make([]string, 0, len(expected))
+		for pages := 1; ; pages++ {
+			page, err := s.FindRequests(nil, paginator)
+			require.Nil(t, err)
+			for _, req := range page.Requests {
+				listed = append(listed, req.ID)
+			}
+			if len(page.NextCursor) == 0 {
+				require.Equal(t, 3, pages)
+				break
+			}
+			paginator.Cursor = page.NextCursor
+
+			// Deleted request does not shift following pages
+			if pages == 1 {
+				require.Nil(t, s.DeleteRequest(page.Requests[0].ID))
+			}
+		}
+		require.Equal(t, expected, listed, sort)
+	}
+}
+
+func TestMemoryStorage_FindRequestsByHeader(t *testing.T) {
+	s := memory.NewMemoryStorage()
+	require.NotNil(t, s)
+
+	// Populate storage with data
+	for _, accept := range []string{"text/html", "application/json", ""} {
+		var headers map[string][]string
+		if len(accept) > 0 {
+			headers = map[string][]string{"Accept": {accept, "*/*"}}
+		}
+		ID, err := s.AddRequest(&model.FetchData{
+			Method:  "GET",
+			URL:     "http://google.com",
+			Headers: headers,
+			Body:    "",
+		})
+		require.Nil(t, err)
+		require.Nil(t, s.AddResponse(ID, &model.Response{
+			ID:      ID,
+			Status:  http.StatusOK,
+			Headers: map[string][]string{"Content-Type": {"text/html"}},
+			Length:  0,
+		}))
+	}
+
+	byHeader := func(name, value string, paginator *model.Paginator) []model.Request {
+		page, err := s.FindRequests(&model.Filter{Header: name, HeaderValue: value}, paginator)
+		require.Nil(t, err)
+		return page.Requests
+	}
+
+	// Search by header name and value
+	require.Equal(t, 2, len(byHeader("Accept", "", nil)))
+	require.Equal(t, 2, len(byHeader("Accept", "*/*", nil)))
+	require.Equal(t, 1, len(byHeader("Accept", "application/json", nil)))
+	require.Equal(t, 3, len(byHeader("Content-Type", "text/html", nil)))
+	require.Empty(t, byHeader("Content-Type", "application/json", nil))
+	require.Empty(t, byHeader("Cookie", "", nil))
+
+	// Get filtered requests for one page
+	require.Equal(t, 1, len(byHeader("Content-Type", "", &model.Paginator{
+		Page:            1,
+		RequestsPerPage: 2,
+	})))
+}
+
+func TestMemoryStorage_HeaderCase(t *testing.T) {
+	s := memory.NewMemoryStorage()
+
+	// Headers are saved as received
+	data := &model.FetchData{
+		Method:  "GET",
+		URL:     "http://google.com",
+		Headers: map[string][]string{"x-request-id": {"abc"}, "X-REQUEST-ID": {"def"}},
+	}
+	ID, err := s.AddRequest(data)
+	require.Nil(t, err)
+	require.Nil(t, s.AddResponse(ID, &model.Response{
+		ID:      ID,
+		Status:  http.StatusOK,
+		Headers: map[string][]string{"content-type": {"text/html"}},
+	}))
+	req, err := s.GetRequest(ID)
+	require.Nil(t, err)
+	require.Equal(t, map[string][]string{"x-request-id": {"abc"}, "X-REQUEST-ID": {"def"}}, req.Fetch.Headers)
+	require.Equal(t, map[string][]string{"content-type": {"text/html"}}, req.Response.Headers)
+
+	// Header names are searched in any case
+	for _, filter := range []*model.Filter{
+		{Header: "X-Request-ID", HeaderValue: "abc"},
+		{Header: "x-request-id", HeaderValue: "def"},
+		{Header: "x-request-id"},
+		{Header: "content-type", HeaderValue: "text/html"},
+		{Header: "CONTENT-TYPE"},
+	} {
+		page, err := s.FindRequests(filter, nil)
+		require.Nil(t, err)
+		require.Equal(t, 1, page.Total, filter.Header)
+	}
+}
+
+func TestMemoryStorage_FindRequests(t *testing.T) {
+	s := memory.NewMemoryStorage()
+
+	// Populate storage with fetched requests
+	requests := []struct {
+		data     model.FetchData
+		response *model.Response
+	}{
+		{
+			data:     model.FetchData{Method: "GET", URL: "http://google.com/search?q=Go"},
+			response: &model.Response{Status: http.StatusOK},
+		},
+		{
+			data:     model.FetchData{Method: "POST", URL: "https://API.example.com/v1/items"},
+			response: &model.Response{Status: http.StatusServiceUnavailable},
+		},
+		{
+			data: model.FetchData{Method: "GET", URL: "https://api.example.com/v2/items"},
+			response: &model.Response{Error: &model.FetchError{
+				Kind:    model.ErrorKindTimeout,
+				Message: "deadline exceeded",
+			}},
+		},
+		{
+			data: model.FetchData{Method: "GET", URL: "http://example.org"},
+		},
+	}
+	generatedID := make([]string, 0, len(requests))
+	for i := range requests {
+		ID, err := s.AddRequest(&requests[i].data)
+		require.Nil(t, err)
+		if requests[i].response != nil {
+			require.Nil(t, s.AddResponse(ID, requests[i].response))
+		}
+		generatedID = append(generatedID, ID)
+	}
+	first, err := s.GetRequest(generatedID[0])
+	require.Nil(t, err)
+	last, err := s.GetRequest(generatedID[3])
+	require.Nil(t, err)
+
+	testCases := []struct {
+		name     string
+		filter   *model.Filter
+		expected []string
+	}{
+		{"all", nil, generatedID},
+		{"method", &model.Filter{Method: "GET"}, []string{generatedID[0], generatedID[2], generatedID[3]}},
+		{"host", &model.Filter{Host: "api.example.COM"}, generatedID[1:3]},
+		{"url prefix", &model.Filter{URLPrefix: "https://api.example.com/"}, generatedID[2:3]},
+		{"search", &model.Filter{Search: "ITEMS"}, generatedID[1:3]},
+		{"status", &model.Filter{Status: http.StatusOK}, generatedID[:1]},
+		{"status class", &model.Filter{StatusClass: 5}, generatedID[1:2]},
+		{"error kind", &model.Filter{ErrorKind: model.ErrorKindTimeout}, generatedID[2:3]},
+		{"created from", &model.Filter{CreatedFrom: last.CreatedAt}, generatedID[3:]},
+		{"created to", &model.Filter{CreatedTo: last.CreatedAt}, generatedID[:3]},
+		{"created range", &model.Filter{CreatedFrom: first.CreatedAt, CreatedTo: first.CreatedAt}, []string{}},
+		{"combined", &model.Filter{Method: "GET", Host: "api.example.com"}, generatedID[2:3]},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			page, err := s.FindRequests(tc.filter, nil)
+			require.Nil(t, err)
+			require.Equal(t, len(tc.expected), page.Total)
+			listed := make([]string, 0, len(page.Requests))
+			for _, req := range page.Requests {
+				listed = append(listed, req.ID)
+			}
+			require.Equal(t, tc.expected, listed)
+		})
+	}
+}
+
+func TestMemoryStorage_InvalidPaginator(t *testing.T) {
+	s := memory.NewMemoryStorage()
+
+	_, err := s.FindRequests(nil, &model.Paginator{Cursor: "garbage"})
+	require.Equal(t, storage.ErrInvalidCursor, err)
+
+	_, err = s.FindRequests(nil, &model.Paginator{Sort: "url"})
+	require.Equal(t, storage.ErrInvalidSort, err)
+
+	_, err = s.FindRequests(nil, &model.Paginator{RequestsPerPage: -1})
+	require.Equal(t, storage.ErrInvalidInputData, err)
+
+	// Cursor of one sort order is rejected by another one
+	for i := 0; i < 3; i++ {
+		_, err = s.AddRequest(&model.FetchData{Method: "GET", URL: "http://google.com"})
+		require.Nil(t, err)
+	}
+	page, err := s.FindRequests(nil, &model.Paginator{RequestsPerPage: 1})
+	require.Nil(t, err)
+	_, err = s.FindRequests(nil, &model.Paginator{RequestsPerPage: 1, Cursor: page.NextCursor, Sort: model.SortCreatedDesc})
+	require.Equal(t, storage.ErrInvalidCursor, err)
 }
 
 func TestMemoryStorage_DeleteRequest(t *testing.T) {
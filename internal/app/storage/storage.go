@@ -1,6 +1,10 @@
 package storage
 
-import "github.com/ahamtat/itvbackend/internal/app/model"
+import (
+	"context"
+
+	"github.com/ahamtat/itvbackend/internal/app/model"
+)
 
 // Storage for application requests.
 type Storage interface {
@@ -10,9 +14,33 @@ type Storage interface {
 	// AddResponse saves response from external resource by request ID.
 	AddResponse(ID string, response *model.Response) error
 
-	// GetAllRequests reads all requests from storage.
-	GetAllRequests(paginator *model.Paginator) []model.Request
+	// UpdateState changes request processing state by ID.
+	UpdateState(ID string, state model.State) error
+
+	// FailRequest marks request as failed and saves failure reason by ID.
+	FailRequest(ID string, reason string) error
+
+	// GetRequest reads request from storage by ID.
+	GetRequest(ID string) (*model.Request, error)
+
+	// FindRequests reads page of requests matching filter.
+	// Nil filter matches all requests.
+	FindRequests(filter *model.Filter, paginator *model.Paginator) (*model.Page, error)
 
 	// DeleteRequest removes request from storage by ID.
 	DeleteRequest(ID string) error
 }
+
+// Binder is implemented by storage logging operations with fields of API call.
+type Binder interface {
+	// Bind returns storage logging operations with fields of API call in context.
+	Bind(ctx context.Context) Storage
+}
+
+// Bind returns storage bound to context of single call, storage not implementing Binder is returned as is.
+func Bind(ctx context.Context, storage Storage) Storage {
+	if binder, ok := storage.(Binder); ok {
+		return binder.Bind(ctx)
+	}
+	return storage
+}
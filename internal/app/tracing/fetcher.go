@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ahamtat/itvbackend/internal/app/fetcher"
+	"github.com/ahamtat/itvbackend/internal/app/model"
+)
+
+// Fetcher decorates fetcher recording every fetch as client span of trace in context.
+type Fetcher struct {
+	fetcher fetcher.Fetcher
+}
+
+// NewFetcher constructor.
+func NewFetcher(fetcher fetcher.Fetcher) fetcher.Fetcher {
+	return &Fetcher{fetcher: fetcher}
+}
+
+// Fetch data from external resource within client span.
+// Trace context is injected into fetch data headers if propagation is turned on.
+func (f *Fetcher) Fetch(ctx context.Context, id string, data *model.FetchData) (*model.Response, error) {
+	ctx, span := Tracer().Start(ctx, "HTTP "+data.Method,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.HTTPMethodKey.String(data.Method),
+			semconv.HTTPURLKey.String(data.URL),
+			semconv.NetPeerNameKey.String(data.HostName()),
+			attribute.String("request.uuid", id)))
+	defer span.End()
+
+	if atomic.LoadInt32(&propagate) == 1 {
+		data = withTraceHeaders(ctx, data)
+	}
+
+	resp, err := f.fetcher.Fetch(ctx, id, data)
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case resp.Error != nil:
+		span.RecordError(resp.Error)
+		span.SetAttributes(attribute.String("error.kind", string(resp.Error.Kind)))
+		span.SetStatus(codes.Error, resp.Error.Error())
+	default:
+		span.SetAttributes(semconv.HTTPAttributesFromHTTPStatusCode(resp.Status)...)
+		span.SetAttributes(semconv.HTTPResponseContentLengthKey.Int64(resp.Length))
+		span.SetStatus(semconv.SpanStatusFromHTTPStatusCode(resp.Status))
+	}
+	if resp != nil && len(resp.Attempts) > 0 {
+		span.SetAttributes(attribute.Int("http.attempts", len(resp.Attempts)))
+	}
+	return resp, err
+}
+
+// withTraceHeaders returns copy of fetch data with trace context headers.
+// Fetch data saved to storage is kept intact.
+func withTraceHeaders(ctx context.Context, data *model.FetchData) *model.FetchData {
+	headers := http.Header{}
+	for name, values := range data.Headers {
+		headers[name] = append([]string(nil), values...)
+	}
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(headers))
+
+	copied := *data
+	copied.Headers = headers
+	return &copied
+}
@@ -0,0 +1,96 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/ahamtat/itvbackend/internal/app/storage"
+)
+
+// Storage decorates storage recording every operation as span of trace in context.
+type Storage struct {
+	ctx     context.Context
+	storage storage.Storage
+}
+
+// NewStorage constructor, storage is bound to context of single call.
+func NewStorage(ctx context.Context, st storage.Storage) storage.Storage {
+	return &Storage{ctx: ctx, storage: storage.Bind(ctx, st)}
+}
+
+// start begins span of storage operation.
+func (s *Storage) start(operation string, attributes ...attribute.KeyValue) trace.Span {
+	_, span := Tracer().Start(s.ctx, "storage."+operation,
+		trace.WithAttributes(append(attributes, attribute.String("db.operation", operation))...))
+	return span
+}
+
+// end finishes span of storage operation recording its error.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// AddRequest saves fetch data and return ID.
+func (s *Storage) AddRequest(data *model.FetchData) (string, error) {
+	span := s.start("add_request")
+	ID, err := s.storage.AddRequest(data)
+	span.SetAttributes(attribute.String("request.uuid", ID))
+	end(span, err)
+	return ID, err
+}
+
+// AddResponse saves response from external resource by request ID.
+func (s *Storage) AddResponse(id string, response *model.Response) error {
+	span := s.start("add_response", attribute.String("request.uuid", id))
+	err := s.storage.AddResponse(id, response)
+	end(span, err)
+	return err
+}
+
+// UpdateState changes request processing state by ID.
+func (s *Storage) UpdateState(id string, state model.State) error {
+	span := s.start("update_state", attribute.String("request.uuid", id), attribute.String("request.state", string(state)))
+	err := s.storage.UpdateState(id, state)
+	end(span, err)
+	return err
+}
+
+// FailRequest marks request as failed and saves failure reason by ID.
+func (s *Storage) FailRequest(id string, reason string) error {
+	span := s.start("fail_request", attribute.String("request.uuid", id))
+	err := s.storage.FailRequest(id, reason)
+	end(span, err)
+	return err
+}
+
+// GetRequest reads request from storage by ID.
+func (s *Storage) GetRequest(id string) (*model.Request, error) {
+	span := s.start("get_request", attribute.String("request.uuid", id))
+	req, err := s.storage.GetRequest(id)
+	end(span, err)
+	return req, err
+}
+
+// FindRequests reads page of requests matching filter.
+func (s *Storage) FindRequests(filter *model.Filter, paginator *model.Paginator) (*model.Page, error) {
+	span := s.start("find_requests")
+	page, err := s.storage.FindRequests(filter, paginator)
+	end(span, err)
+	return page, err
+}
+
+// DeleteRequest removes request from storage by ID.
+func (s *Storage) DeleteRequest(id string) error {
+	span := s.start("delete_request", attribute.String("request.uuid", id))
+	err := s.storage.DeleteRequest(id)
+	end(span, err)
+	return err
+}
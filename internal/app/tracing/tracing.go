@@ -0,0 +1,137 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentation names tracer of application spans.
+const instrumentation = "github.com/ahamtat/itvbackend"
+
+// Span exporters.
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+)
+
+// ErrUnknownExporter is returned for unsupported span exporter.
+var ErrUnknownExporter = errors.New("unknown span exporter")
+
+// Settings of tracing.
+type Settings struct {
+	// Span exporter: none, stdout or otlp
+	Exporter string
+	// OTLP HTTP collector endpoint as host:port, empty is default one
+	Endpoint string
+	// Send spans to OTLP collector without TLS
+	Insecure bool
+	// Service name of resource
+	ServiceName string
+	// Fraction of sampled traces not sampled by parent, one samples all traces
+	SampleRatio float64
+	// Inject trace context into requests to external resources
+	Propagate bool
+	// Writer of stdout exporter, nil is standard output
+	Writer io.Writer
+}
+
+// DefaultSettings do not export spans.
+var DefaultSettings = Settings{
+	Exporter:    ExporterNone,
+	ServiceName: "itvbackend",
+	SampleRatio: 1,
+}
+
+// propagate is set when trace context is injected into fetch data headers.
+var propagate int32
+
+// Setup installs global tracer provider exporting spans and W3C trace context propagator.
+// Incoming trace context is propagated even if spans are not exported.
+// Returned function flushes and stops exporter.
+func Setup(ctx context.Context, settings Settings) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+	SetPropagation(settings.Propagate)
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch settings.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		writer := settings.Writer
+		if writer == nil {
+			writer = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(writer))
+	case ExporterOTLP:
+		options := make([]otlptracehttp.Option, 0)
+		if len(settings.Endpoint) > 0 {
+			options = append(options, otlptracehttp.WithEndpoint(settings.Endpoint))
+		}
+		if settings.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, options...)
+	default:
+		return nil, ErrUnknownExporter
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(settings.SampleRatio))),
+		sdktrace.WithResource(resource.NewWithAttributes(
+			semconv.SchemaURL,
+			semconv.ServiceNameKey.String(settings.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// SetPropagation turns on or off injecting trace context into requests to external resources.
+func SetPropagation(enabled bool) {
+	var value int32
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&propagate, value)
+}
+
+// Tracer of application spans from global tracer provider.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// TraceParent returns W3C traceparent of span in context, it is empty without span.
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.HeaderCarrier{}
+	propagation.TraceContext{}.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// WithTraceParent returns context continuing trace of W3C traceparent.
+// Empty or invalid traceparent returns context as is.
+func WithTraceParent(ctx context.Context, traceParent string) context.Context {
+	if len(traceParent) == 0 {
+		return ctx
+	}
+	carrier := propagation.HeaderCarrier{}
+	carrier.Set("traceparent", traceParent)
+	return propagation.TraceContext{}.Extract(ctx, carrier)
+}
@@ -0,0 +1,161 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+
+	"github.com/ahamtat/itvbackend/internal/app/model"
+	"github.com/ahamtat/itvbackend/internal/app/storage/memory"
+	"github.com/ahamtat/itvbackend/internal/app/tracing"
+)
+
+// record installs tracer provider recording ended spans.
+func record(t *testing.T, propagate bool) *tracetest.SpanRecorder {
+	_, err := tracing.Setup(context.Background(), tracing.Settings{Exporter: tracing.ExporterNone, Propagate: propagate})
+	require.Nil(t, err)
+
+	sr := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr))
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		tracing.SetPropagation(false)
+		_ = provider.Shutdown(context.Background())
+	})
+	return sr
+}
+
+// attributes of span by key.
+func attributes(span sdktrace.ReadOnlySpan) map[attribute.Key]attribute.Value {
+	result := make(map[attribute.Key]attribute.Value)
+	for _, kv := range span.Attributes() {
+		result[kv.Key] = kv.Value
+	}
+	return result
+}
+
+// capturingFetcher remembers fetch data and returns given response.
+type capturingFetcher struct {
+	data *model.FetchData
+	resp *model.Response
+	err  error
+}
+
+func (f *capturingFetcher) Fetch(_ context.Context, _ string, data *model.FetchData) (*model.Response, error) {
+	f.data = data
+	return f.resp, f.err
+}
+
+func TestTraceParent(t *testing.T) {
+	record(t, false)
+
+	require.Empty(t, tracing.TraceParent(context.Background()))
+	require.Equal(t, context.Background(), tracing.WithTraceParent(context.Background(), ""))
+
+	ctx, span := tracing.Tracer().Start(context.Background(), "api")
+	defer span.End()
+	traceParent := tracing.TraceParent(ctx)
+	require.Contains(t, traceParent, span.SpanContext().TraceID().String())
+
+	// Span started from restored context continues trace
+	_, child := tracing.Tracer().Start(tracing.WithTraceParent(context.Background(), traceParent), "task")
+	defer child.End()
+	require.Equal(t, span.SpanContext().TraceID(), child.SpanContext().TraceID())
+}
+
+func TestSetup_UnknownExporter(t *testing.T) {
+	_, err := tracing.Setup(context.Background(), tracing.Settings{Exporter: "zipkin"})
+	require.Equal(t, tracing.ErrUnknownExporter, err)
+}
+
+func TestFetcher(t *testing.T) {
+	sr := record(t, false)
+	f := &capturingFetcher{resp: &model.Response{Status: http.StatusNotFound, Length: 9}}
+	data := &model.FetchData{Method: http.MethodGet, URL: "http://google.com/search"}
+
+	ctx, parent := tracing.Tracer().Start(context.Background(), "api")
+	_, err := tracing.NewFetcher(f).Fetch(ctx, "id", data)
+	parent.End()
+	require.Nil(t, err)
+
+	// Trace context is not injected without propagation
+	require.Equal(t, data, f.data)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 2)
+	span := spans[0]
+	require.Equal(t, "HTTP GET", span.Name())
+	require.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	attrs := attributes(span)
+	require.Equal(t, "http://google.com/search", attrs["http.url"].AsString())
+	require.Equal(t, "google.com", attrs["net.peer.name"].AsString())
+	require.Equal(t, "id", attrs["request.uuid"].AsString())
+	require.Equal(t, int64(http.StatusNotFound), attrs["http.status_code"].AsInt64())
+	require.Equal(t, codes.Error, span.Status().Code)
+}
+
+func TestFetcher_Propagation(t *testing.T) {
+	record(t, true)
+	f := &capturingFetcher{resp: &model.Response{Status: http.StatusOK}}
+	data := &model.FetchData{
+		Method:  http.MethodGet,
+		URL:     "http://google.com",
+		Headers: map[string][]string{"Accept": {"text/html"}},
+	}
+
+	ctx, parent := tracing.Tracer().Start(context.Background(), "api")
+	defer parent.End()
+	_, err := tracing.NewFetcher(f).Fetch(ctx, "id", data)
+	require.Nil(t, err)
+
+	// Outbound request carries trace context, saved fetch data is intact
+	require.Contains(t, http.Header(f.data.Headers).Get("traceparent"), parent.SpanContext().TraceID().String())
+	require.Equal(t, "text/html", http.Header(f.data.Headers).Get("Accept"))
+	require.NotContains(t, data.Headers, "Traceparent")
+}
+
+func TestFetcher_Error(t *testing.T) {
+	sr := record(t, false)
+	f := &capturingFetcher{resp: &model.Response{Error: &model.FetchError{Kind: model.ErrorKindTimeout}}}
+
+	_, err := tracing.NewFetcher(f).Fetch(context.Background(), "id", &model.FetchData{Method: http.MethodGet, URL: "http://google.com"})
+	require.Nil(t, err)
+	_, err = tracing.NewFetcher(&capturingFetcher{err: errors.New("rejected")}).
+		Fetch(context.Background(), "id", &model.FetchData{Method: http.MethodGet, URL: "http://google.com"})
+	require.NotNil(t, err)
+
+	spans := sr.Ended()
+	require.Len(t, spans, 2)
+	require.Equal(t, string(model.ErrorKindTimeout), attributes(spans[0])["error.kind"].AsString())
+	require.Equal(t, codes.Error, spans[0].Status().Code)
+	require.Equal(t, codes.Error, spans[1].Status().Code)
+}
+
+func TestStorage(t *testing.T) {
+	sr := record(t, false)
+	ctx, parent := tracing.Tracer().Start(context.Background(), "api")
+	st := tracing.NewStorage(ctx, memory.NewMemoryStorage())
+
+	id, err := st.AddRequest(&model.FetchData{Method: http.MethodGet, URL: "http://google.com"})
+	require.Nil(t, err)
+	_, err = st.GetRequest("unknown")
+	require.NotNil(t, err)
+	parent.End()
+
+	spans := sr.Ended()
+	require.Len(t, spans, 3)
+	require.Equal(t, "storage.add_request", spans[0].Name())
+	require.Equal(t, id, attributes(spans[0])["request.uuid"].AsString())
+	require.Equal(t, "add_request", attributes(spans[0])["db.operation"].AsString())
+	require.Equal(t, parent.SpanContext().TraceID(), spans[0].SpanContext().TraceID())
+	require.Equal(t, "storage.get_request", spans[1].Name())
+	require.Equal(t, codes.Error, spans[1].Status().Code)
+}
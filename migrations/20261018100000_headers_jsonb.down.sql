@@ -0,0 +1,6 @@
+DROP INDEX requests_response_headers_idx;
+DROP INDEX requests_fetch_headers_idx;
+
+ALTER TABLE requests
+    ALTER COLUMN fetch_headers TYPE varchar USING fetch_headers::text,
+    ALTER COLUMN response_headers TYPE varchar USING response_headers::text;
@@ -0,0 +1,15 @@
+-- Headers were saved as "Name: [value]; Name: [value]" text. Every header is converted
+-- to single value array, since values separated by space can not be told apart.
+CREATE FUNCTION pg_temp.headers_to_jsonb(headers varchar) RETURNS jsonb AS $$
+    SELECT jsonb_object_agg(parts[1], jsonb_build_array(parts[2]))
+    FROM regexp_matches(headers, '([^\s:;\[\]]+): \[([^\]]*)\]', 'g') AS match(parts)
+$$ LANGUAGE sql IMMUTABLE;
+
+ALTER TABLE requests
+    ALTER COLUMN fetch_headers TYPE jsonb USING pg_temp.headers_to_jsonb(fetch_headers),
+    ALTER COLUMN response_headers TYPE jsonb USING pg_temp.headers_to_jsonb(response_headers);
+
+DROP FUNCTION pg_temp.headers_to_jsonb(varchar);
+
+CREATE INDEX requests_fetch_headers_idx ON requests USING gin (fetch_headers);
+CREATE INDEX requests_response_headers_idx ON requests USING gin (response_headers);
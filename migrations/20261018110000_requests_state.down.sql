@@ -0,0 +1,3 @@
+DROP INDEX requests_uuid_idx;
+
+ALTER TABLE requests DROP COLUMN state;
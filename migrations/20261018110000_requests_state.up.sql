@@ -0,0 +1,3 @@
+ALTER TABLE requests ADD COLUMN state varchar NOT NULL DEFAULT 'queued';
+
+CREATE UNIQUE INDEX requests_uuid_idx ON requests (uuid);
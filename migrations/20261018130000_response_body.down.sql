@@ -0,0 +1,3 @@
+ALTER TABLE requests
+    DROP COLUMN truncated,
+    DROP COLUMN response_body;
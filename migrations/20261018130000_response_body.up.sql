@@ -0,0 +1,3 @@
+ALTER TABLE requests
+    ADD COLUMN response_body bytea,
+    ADD COLUMN truncated boolean NOT NULL DEFAULT false;
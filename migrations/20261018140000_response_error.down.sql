@@ -0,0 +1,3 @@
+ALTER TABLE requests
+    DROP COLUMN error_message,
+    DROP COLUMN error_kind;
@@ -0,0 +1,3 @@
+ALTER TABLE requests
+    ADD COLUMN error_kind varchar,
+    ADD COLUMN error_message varchar;
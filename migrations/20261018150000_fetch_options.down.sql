@@ -0,0 +1,3 @@
+ALTER TABLE requests
+    DROP COLUMN redirects,
+    DROP COLUMN options;
@@ -0,0 +1,3 @@
+ALTER TABLE requests
+    ADD COLUMN options jsonb,
+    ADD COLUMN redirects jsonb;
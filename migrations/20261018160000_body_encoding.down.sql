@@ -0,0 +1,4 @@
+ALTER TABLE requests
+    DROP COLUMN parts,
+    DROP COLUMN form,
+    DROP COLUMN body_encoding;
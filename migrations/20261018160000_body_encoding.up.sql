@@ -0,0 +1,4 @@
+ALTER TABLE requests
+    ADD COLUMN body_encoding varchar,
+    ADD COLUMN form jsonb,
+    ADD COLUMN parts jsonb;
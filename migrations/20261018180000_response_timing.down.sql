@@ -0,0 +1 @@
+ALTER TABLE requests DROP COLUMN timing;
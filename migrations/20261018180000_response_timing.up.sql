@@ -0,0 +1 @@
+ALTER TABLE requests ADD COLUMN timing jsonb;
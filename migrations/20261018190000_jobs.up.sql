@@ -0,0 +1,13 @@
+CREATE TABLE jobs (
+    id bigserial not null primary key,
+    request_uuid uuid not null unique references requests (uuid) ON DELETE CASCADE,
+    locked_by varchar,
+    locked_until timestamptz,
+    claims integer not null default 0
+);
+
+CREATE INDEX jobs_locked_until_idx ON jobs (locked_until);
+
+-- Resume requests left unfinished by in-memory task queue
+INSERT INTO jobs (request_uuid)
+    SELECT uuid FROM requests WHERE state IN ('queued', 'running') ORDER BY id;
@@ -0,0 +1,3 @@
+ALTER TABLE requests ADD COLUMN created_at timestamptz NOT NULL DEFAULT now();
+
+CREATE INDEX requests_created_at_idx ON requests (created_at, id);
@@ -0,0 +1,7 @@
+DROP INDEX requests_error_kind_idx;
+DROP INDEX requests_status_idx;
+DROP INDEX requests_url_trgm_idx;
+DROP INDEX requests_url_prefix_idx;
+DROP INDEX requests_method_idx;
+
+ALTER TABLE requests DROP COLUMN host;
@@ -0,0 +1,12 @@
+ALTER TABLE requests ADD COLUMN host varchar;
+
+UPDATE requests SET host = lower(btrim(substring(url from '^[^:/?#]+://(?:[^@/?#]*@)?(\[[^]]*\]|[^:/?#]*)'), '[]'));
+
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX requests_method_idx ON requests (method, created_at);
+CREATE INDEX requests_host_idx ON requests (host, created_at);
+CREATE INDEX requests_url_prefix_idx ON requests (url varchar_pattern_ops);
+CREATE INDEX requests_url_trgm_idx ON requests USING gin (url gin_trgm_ops);
+CREATE INDEX requests_status_idx ON requests (status, created_at);
+CREATE INDEX requests_error_kind_idx ON requests (error_kind, created_at);
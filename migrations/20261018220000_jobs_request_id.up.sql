@@ -0,0 +1 @@
+ALTER TABLE jobs ADD COLUMN request_id varchar;
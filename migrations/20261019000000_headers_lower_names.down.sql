@@ -0,0 +1,4 @@
+DROP INDEX requests_response_header_names_idx;
+DROP INDEX requests_fetch_header_names_idx;
+
+DROP FUNCTION lower_header_names(jsonb);
@@ -0,0 +1,15 @@
+-- Headers are saved as received, lower case names are indexed to search headers in any case.
+-- Values of names differing in case only are merged, names without values are kept.
+CREATE FUNCTION lower_header_names(headers jsonb) RETURNS jsonb AS $$
+    SELECT coalesce(jsonb_object_agg(name, header_values), '{}'::jsonb)
+    FROM (
+        SELECT lower(header.key) AS name,
+            coalesce(jsonb_agg(header_value) FILTER (WHERE header_value IS NOT NULL), '[]'::jsonb) AS header_values
+        FROM jsonb_each(headers) AS header
+        LEFT JOIN LATERAL jsonb_array_elements(header.value) AS elements(header_value) ON true
+        GROUP BY 1
+    ) AS lowered
+$$ LANGUAGE sql IMMUTABLE STRICT;
+
+CREATE INDEX requests_fetch_header_names_idx ON requests USING gin (lower_header_names(fetch_headers));
+CREATE INDEX requests_response_header_names_idx ON requests USING gin (lower_header_names(response_headers));
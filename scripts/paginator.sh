@@ -15,3 +15,14 @@ curl --header "Content-Type: application/json" \
   --request GET \
   --data '{"page":2,"requestsPerPage":2}' \
   http://localhost:8080/v1/requests/list
+
+# Walk newest requests by cursor
+cursor=$(curl --silent --output /dev/null --dump-header - \
+  --header "Content-Type: application/json" \
+  --request GET \
+  --data '{"requestsPerPage":3,"sort":"-createdAt"}' \
+  http://localhost:8080/v1/requests/list | grep -i '^X-Next-Cursor:' | cut -d' ' -f2 | tr -d '\r')
+curl --header "Content-Type: application/json" \
+  --request GET \
+  --data '{"requestsPerPage":3,"sort":"-createdAt"}' \
+  "http://localhost:8080/v1/requests/list?cursor=$cursor"